@@ -0,0 +1,215 @@
+/*
+ *
+ * Copyright 2024 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package serverxds
+
+import (
+	"fmt"
+	"net"
+
+	"google.golang.org/grpc/xds/internal/xdsclient/xdsresource"
+)
+
+// filterChain holds the resolved configuration (route configuration name and
+// HTTP filters) for a single matched filter chain out of a Listener resource.
+type filterChain struct {
+	routeConfigName string
+	httpFilters     []xdsresource.HTTPFilter
+}
+
+type compiledFilterChain struct {
+	match *xdsresource.FilterChainMatch
+	chain *filterChain
+}
+
+// filterChainManager matches incoming connections against the filter chains
+// of a single, most-recently-ACKed Listener resource. Selection narrows
+// through each criterion in the order defined by the xDS Listener proto
+// (destination port, destination prefix ranges, source prefix ranges, source
+// ports), discarding chains that don't share the most specific match found
+// at each step, so that the unique best-matching chain survives rather than
+// whichever happened to be listed first. Source type is not evaluated:
+// FilterChainMatch carries no source type field.
+//
+// Server-name (SNI), transport-protocol and application-protocol (ALPN)
+// narrowing apply to the TLS ClientHello and are not evaluated here, since
+// ListenerWrapper.Accept operates before any TLS handshake has taken place;
+// callers that need that level of selection must perform it against the
+// subset returned by match, once the ClientHello has been peeked.
+type filterChainManager struct {
+	chains []*compiledFilterChain
+	// defaultChain is used when no chain matches and update carried a
+	// default_filter_chain.
+	defaultChain *filterChain
+}
+
+func newFilterChainManager(update *xdsresource.ListenerUpdate) (*filterChainManager, error) {
+	if update == nil {
+		return nil, fmt.Errorf("serverxds: nil ListenerUpdate")
+	}
+
+	fcm := &filterChainManager{}
+	for i := range update.FilterChains {
+		fc := &update.FilterChains[i]
+		fcm.chains = append(fcm.chains, &compiledFilterChain{
+			match: fc.FilterChainMatch,
+			chain: &filterChain{
+				routeConfigName: fc.RouteConfigName,
+				httpFilters:     fc.HTTPFilters,
+			},
+		})
+	}
+	if update.DefaultFilterChain != nil {
+		fcm.defaultChain = &filterChain{
+			routeConfigName: update.DefaultFilterChain.RouteConfigName,
+			httpFilters:     update.DefaultFilterChain.HTTPFilters,
+		}
+	}
+	return fcm, nil
+}
+
+// match returns the single most-specific filter chain that matches a
+// connection accepted on local, coming from remote, falling back to the
+// Listener's default_filter_chain if none of the explicit chains match. If
+// more than one chain remains equally specific, which indicates a
+// misconfigured Listener, not a case to guess on, it returns an error and
+// does not use the default chain.
+func (fcm *filterChainManager) match(local, remote net.Addr) (*filterChain, error) {
+	if fcm == nil {
+		return nil, fmt.Errorf("serverxds: no filter chain configuration available")
+	}
+
+	candidates := fcm.chains
+
+	candidates = narrowByDestinationPort(candidates, local)
+	candidates = narrowByPrefixRange(candidates, local, func(m *xdsresource.FilterChainMatch) []xdsresource.CIDRRange { return m.PrefixRanges })
+	candidates = narrowByPrefixRange(candidates, remote, func(m *xdsresource.FilterChainMatch) []xdsresource.CIDRRange { return m.SourcePrefixRanges })
+	candidates = narrowBySourcePort(candidates, remote)
+
+	switch len(candidates) {
+	case 1:
+		return candidates[0].chain, nil
+	case 0:
+		if fcm.defaultChain != nil {
+			return fcm.defaultChain, nil
+		}
+		return nil, fmt.Errorf("serverxds: no filter chain matched connection from %v to %v", remote, local)
+	default:
+		return nil, fmt.Errorf("serverxds: %d equally specific filter chains matched connection from %v to %v; listener configuration is ambiguous", len(candidates), remote, local)
+	}
+}
+
+// narrowByDestinationPort keeps only the chains matching local's port: those
+// with an explicit DestinationPort take precedence over those without one
+// (an unset DestinationPort matches any port, but is less specific).
+func narrowByDestinationPort(chains []*compiledFilterChain, local net.Addr) []*compiledFilterChain {
+	tcpAddr, ok := local.(*net.TCPAddr)
+	if !ok {
+		return chains
+	}
+
+	var withPort []*compiledFilterChain
+	var withoutPort []*compiledFilterChain
+	for _, c := range chains {
+		if c.match.DestinationPort == 0 {
+			withoutPort = append(withoutPort, c)
+			continue
+		}
+		if c.match.DestinationPort == uint32(tcpAddr.Port) {
+			withPort = append(withPort, c)
+		}
+	}
+	if len(withPort) > 0 {
+		return withPort
+	}
+	return withoutPort
+}
+
+// narrowBySourcePort is narrowByDestinationPort's source-port counterpart.
+func narrowBySourcePort(chains []*compiledFilterChain, remote net.Addr) []*compiledFilterChain {
+	tcpAddr, ok := remote.(*net.TCPAddr)
+	if !ok {
+		return chains
+	}
+
+	var withPort []*compiledFilterChain
+	var withoutPort []*compiledFilterChain
+	for _, c := range chains {
+		if len(c.match.SourcePorts) == 0 {
+			withoutPort = append(withoutPort, c)
+			continue
+		}
+		for _, p := range c.match.SourcePorts {
+			if p == uint32(tcpAddr.Port) {
+				withPort = append(withPort, c)
+				break
+			}
+		}
+	}
+	if len(withPort) > 0 {
+		return withPort
+	}
+	return withoutPort
+}
+
+// narrowByPrefixRange keeps only the chains whose ranges (as selected by
+// rangesOf, either destination or source) contain addr's IP with the
+// longest prefix length found among them; chains specifying no ranges at
+// all match any address, but are less specific than any chain with a
+// matching range.
+func narrowByPrefixRange(chains []*compiledFilterChain, addr net.Addr, rangesOf func(*xdsresource.FilterChainMatch) []xdsresource.CIDRRange) []*compiledFilterChain {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return chains
+	}
+	ip := tcpAddr.IP
+
+	bestLen := -1
+	var best []*compiledFilterChain
+	var wildcard []*compiledFilterChain
+	for _, c := range chains {
+		ranges := rangesOf(c.match)
+		if len(ranges) == 0 {
+			wildcard = append(wildcard, c)
+			continue
+		}
+		matchLen := -1
+		for _, r := range ranges {
+			if r.Net == nil || !r.Net.Contains(ip) {
+				continue
+			}
+			if ones, _ := r.Net.Mask.Size(); ones > matchLen {
+				matchLen = ones
+			}
+		}
+		if matchLen < 0 {
+			continue
+		}
+		switch {
+		case matchLen > bestLen:
+			bestLen = matchLen
+			best = []*compiledFilterChain{c}
+		case matchLen == bestLen:
+			best = append(best, c)
+		}
+	}
+	if len(best) > 0 {
+		return best
+	}
+	return wildcard
+}
@@ -0,0 +1,331 @@
+/*
+ *
+ * Copyright 2024 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package serverxds implements the server side of xDS: a net.Listener that
+// watches the Listener resource for the address it is bound to and only
+// accepts connections while the server is configured to be "serving", gating
+// each accepted connection's filter chain selection on the most recently
+// ACKed LDS/RDS configuration.
+package serverxds
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc/xds/internal/xdsclient"
+	"google.golang.org/grpc/xds/internal/xdsclient/xdsresource"
+)
+
+// ServingModeCallback is the callback invoked by ListenerWrapper whenever its
+// serving mode changes, mirroring the "not-serving"/"serving" transitions
+// described by gRFC A36.
+type ServingModeCallback func(addr net.Addr, mode ServingMode, err error)
+
+// ServingMode is the current mode of a ListenerWrapper.
+type ServingMode int
+
+const (
+	// ServingModeStarting is the initial mode, before the first LDS response
+	// has been ACKed.
+	ServingModeStarting ServingMode = iota
+	// ServingModeServing means the listener is accepting new connections.
+	ServingModeServing
+	// ServingModeNotServing means the listener is not accepting new
+	// connections, because the current Listener resource is missing, was
+	// NACKed, or was deleted.
+	ServingModeNotServing
+)
+
+// ListenerWrapper wraps a net.Listener and layers xDS-driven serving-mode
+// gating and dynamic filter chain matching on top of it, so that a gRPC
+// server can be driven by LDS/RDS the same way a client-side resolver is
+// driven by CDS/EDS, sharing the same underlying xdsclient.Pool (and
+// potentially the same ADS stream) as the process's client-side xDS usage.
+type ListenerWrapper struct {
+	net.Listener
+
+	client xdsclient.XDSClient
+	addr   string
+	modeCb ServingModeCallback
+
+	cancelWatch func()
+
+	mu   sync.Mutex
+	mode ServingMode
+	// fcm matches an incoming connection's source/destination address and
+	// port against the filter chains of the most recently ACKed Listener
+	// resource (and whose RouteConfigurations, if any, have all resolved),
+	// to pick the filter chain (and therefore route configuration name and
+	// HTTP filters) to apply to that connection.
+	fcm *filterChainManager
+	// pendingFCM is built from the most recently ACKed Listener resource,
+	// same as fcm, but is held back from replacing it (and, the first time,
+	// from flipping the listener into serving mode) until every
+	// RouteConfiguration its filter chains reference by name (routeWatches)
+	// has resolved at least once; a filter chain with nothing to route RPCs
+	// with isn't usable yet.
+	pendingFCM *filterChainManager
+	// routeWatches holds the cancel function for every RouteConfiguration
+	// watch started on behalf of pendingFCM/fcm's filter chains, keyed by
+	// route_config_name; resolvedRoutes records which of those names have
+	// resolved at least once since their watch started.
+	routeWatches   map[string]func()
+	resolvedRoutes map[string]bool
+}
+
+// NewListenerWrapper creates a ListenerWrapper that watches the Listener
+// resource named by addr (a "host:port" formatted string matching the
+// listening address, per the xDS server listener resource naming
+// convention) using client, and layers connection gating and filter chain
+// matching on top of lis. client is expected to have been obtained from
+// Pool.NewServerClient, so that the pool's reference counting keeps the
+// underlying xDS client alive for as long as this ListenerWrapper is in use,
+// even if every client-side user of the pool has released its reference.
+//
+// modeCb, if non-nil, is invoked every time the listener's serving mode
+// changes; this lets callers log the transition or update a health check.
+func NewListenerWrapper(client xdsclient.XDSClient, lis net.Listener, addr string, modeCb ServingModeCallback) (*ListenerWrapper, error) {
+	if client == nil {
+		return nil, errors.New("serverxds: a non-nil xdsclient.XDSClient is required")
+	}
+	if lis == nil {
+		return nil, errors.New("serverxds: a non-nil net.Listener is required")
+	}
+
+	lw := &ListenerWrapper{
+		Listener:       lis,
+		client:         client,
+		addr:           addr,
+		modeCb:         modeCb,
+		mode:           ServingModeStarting,
+		routeWatches:   make(map[string]func()),
+		resolvedRoutes: make(map[string]bool),
+	}
+	lw.cancelWatch = xdsresource.WatchListener(client, addr, lw)
+	return lw, nil
+}
+
+// Accept blocks until a connection arrives and the listener is in serving
+// mode, applies the current filter chain match to it, and returns it. While
+// not-serving, connections are accepted (so the OS-level backlog doesn't
+// fill up and start refusing connections outright) and then immediately
+// closed, matching the "fail open at the TCP level, fail closed at the xDS
+// level" behavior described by gRFC A36.
+func (lw *ListenerWrapper) Accept() (net.Conn, error) {
+	for {
+		conn, err := lw.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		lw.mu.Lock()
+		mode, fcm := lw.mode, lw.fcm
+		lw.mu.Unlock()
+
+		if mode != ServingModeServing {
+			conn.Close()
+			continue
+		}
+
+		fc, err := fcm.match(conn.LocalAddr(), conn.RemoteAddr())
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		return &connWrapper{Conn: conn, filterChain: fc}, nil
+	}
+}
+
+// Close stops watching the Listener resource (and any RouteConfiguration
+// resources it was waiting on) and closes the underlying net.Listener. It
+// does not release the caller's reference to the xDS client; the caller is
+// responsible for calling the close function returned alongside the client
+// by Pool.NewServerClient once it is done with it.
+func (lw *ListenerWrapper) Close() error {
+	lw.mu.Lock()
+	for _, cancel := range lw.routeWatches {
+		cancel()
+	}
+	lw.mu.Unlock()
+
+	lw.cancelWatch()
+	return lw.Listener.Close()
+}
+
+// OnUpdate is invoked by the xDS client with a newly ACKed Listener
+// resource. It starts a RouteConfiguration watch for every filter chain's
+// route_config_name that isn't already inlined in the Listener resource
+// (NeedsRDS), and holds off replacing the active filter chain matcher (and,
+// the first time this is called, flipping the listener into serving mode)
+// until every one of those RouteConfigurations has resolved; Accept keeps
+// using whatever configuration, if any, was already active in the
+// meantime.
+func (lw *ListenerWrapper) OnUpdate(update *xdsresource.ListenerUpdate) {
+	fcm, err := newFilterChainManager(update)
+	if err != nil {
+		lw.onError(err)
+		return
+	}
+	needed := routeConfigNamesToWatch(update)
+
+	lw.mu.Lock()
+	for name, cancel := range lw.routeWatches {
+		if needed[name] {
+			continue
+		}
+		cancel()
+		delete(lw.routeWatches, name)
+		delete(lw.resolvedRoutes, name)
+	}
+	for name := range needed {
+		if _, ok := lw.routeWatches[name]; ok {
+			continue
+		}
+		lw.routeWatches[name] = xdsresource.WatchRouteConfig(lw.client, name, &routeConfigWatcher{lw: lw, name: name})
+	}
+	lw.pendingFCM = fcm
+	transitioned := lw.promotePendingLocked()
+	lw.mu.Unlock()
+
+	if transitioned && lw.modeCb != nil {
+		lw.modeCb(lw.Listener.Addr(), ServingModeServing, nil)
+	}
+}
+
+// promotePendingLocked swaps pendingFCM in as the active filter chain
+// matcher, and flips the listener into ServingModeServing, once every
+// RouteConfiguration it depends on (routeWatches) has resolved. mu must be
+// held; it reports whether the caller should invoke modeCb afterwards.
+func (lw *ListenerWrapper) promotePendingLocked() (transitioned bool) {
+	if lw.pendingFCM == nil {
+		return false
+	}
+	for name := range lw.routeWatches {
+		if !lw.resolvedRoutes[name] {
+			return false
+		}
+	}
+	lw.fcm = lw.pendingFCM
+	lw.pendingFCM = nil
+	transitioned = lw.mode != ServingModeServing
+	lw.mode = ServingModeServing
+	return transitioned
+}
+
+// routeConfigNamesToWatch returns the set of distinct route_config_name
+// values update's filter chains reference via RDS (excluding filter chains
+// that carry their RouteConfiguration inline, which need no separate
+// watch), all of which must resolve before the listener can serve
+// connections matched against update.
+func routeConfigNamesToWatch(update *xdsresource.ListenerUpdate) map[string]bool {
+	names := make(map[string]bool)
+	addIfNeeded := func(fc *xdsresource.FilterChainData) {
+		if fc != nil && fc.NeedsRDS && fc.RouteConfigName != "" {
+			names[fc.RouteConfigName] = true
+		}
+	}
+	for i := range update.FilterChains {
+		addIfNeeded(&update.FilterChains[i])
+	}
+	addIfNeeded(update.DefaultFilterChain)
+	return names
+}
+
+// routeConfigWatcher forwards a single RouteConfiguration watch's callbacks
+// back to the ListenerWrapper that started it, remembering which name it
+// watches since xdsresource.RouteConfigWatcher's callbacks don't carry it.
+type routeConfigWatcher struct {
+	lw   *ListenerWrapper
+	name string
+}
+
+func (w *routeConfigWatcher) OnUpdate(*xdsresource.RouteConfigUpdate) {
+	w.lw.mu.Lock()
+	if _, watching := w.lw.routeWatches[w.name]; !watching {
+		// Superseded by a later Listener update before this one resolved.
+		w.lw.mu.Unlock()
+		return
+	}
+	w.lw.resolvedRoutes[w.name] = true
+	transitioned := w.lw.promotePendingLocked()
+	w.lw.mu.Unlock()
+
+	if transitioned && w.lw.modeCb != nil {
+		w.lw.modeCb(w.lw.Listener.Addr(), ServingModeServing, nil)
+	}
+}
+
+// OnError and OnResourceDoesNotExist leave the listener waiting (or, if it
+// already has a fully-resolved configuration serving connections, keep
+// serving that one); a later Listener update is what decides whether this
+// RouteConfiguration is even still needed.
+func (w *routeConfigWatcher) OnError(error) {}
+
+func (w *routeConfigWatcher) OnResourceDoesNotExist() {}
+
+// OnError is invoked by the xDS client when the Listener resource is NACKed
+// or the watch otherwise fails. An existing, previously-ACKed configuration
+// (and therefore serving mode) is left unchanged, per the "continue using
+// the last known good configuration" guidance for xDS clients.
+func (lw *ListenerWrapper) OnError(err error) {
+	lw.mu.Lock()
+	hadConfig := lw.fcm != nil
+	lw.mu.Unlock()
+	if hadConfig {
+		return
+	}
+	lw.onError(err)
+}
+
+// OnResourceDoesNotExist is invoked by the xDS client when the Listener
+// resource named addr does not exist on the management server (or is
+// deleted after having existed). This always puts the listener into
+// not-serving mode: there is no "last known good" configuration to keep
+// using for a resource that no longer exists.
+func (lw *ListenerWrapper) OnResourceDoesNotExist() {
+	lw.onError(fmt.Errorf("serverxds: Listener resource %q does not exist", lw.addr))
+}
+
+func (lw *ListenerWrapper) onError(err error) {
+	lw.mu.Lock()
+	for _, cancel := range lw.routeWatches {
+		cancel()
+	}
+	lw.routeWatches = make(map[string]func())
+	lw.resolvedRoutes = make(map[string]bool)
+	lw.pendingFCM = nil
+	lw.fcm = nil
+	lw.mode = ServingModeNotServing
+	lw.mu.Unlock()
+
+	if lw.modeCb != nil {
+		lw.modeCb(lw.Listener.Addr(), ServingModeNotServing, err)
+	}
+}
+
+// connWrapper associates a net.Conn with the filter chain that was matched
+// for it at Accept time, so that downstream gRPC server code (transport
+// credentials, routing) can apply the right configuration without
+// re-matching.
+type connWrapper struct {
+	net.Conn
+
+	filterChain *filterChain
+}
@@ -0,0 +1,253 @@
+/*
+ *
+ * Copyright 2024 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package serverxds
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	v3listenerpb "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	v3routepb "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	v3hcmpb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	v3discoverypb "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/internal/xds/bootstrap"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"google.golang.org/grpc/xds/internal/xdsclient"
+	"google.golang.org/grpc/xds/internal/xdsclient/xdsresource"
+)
+
+// fakeADSServer is a minimal AggregatedDiscoveryServiceServer that replies to
+// every DiscoveryRequest it receives with whatever response is sent to it on
+// respCh, so that a ListenerWrapper under test can be driven by a real
+// Listener update over a real ADS stream.
+type fakeADSServer struct {
+	v3discoverypb.UnimplementedAggregatedDiscoveryServiceServer
+
+	respCh chan *v3discoverypb.DiscoveryResponse
+}
+
+func newFakeADSServer(t *testing.T) (addr string, srv *fakeADSServer, stop func()) {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen() failed: %v", err)
+	}
+
+	fake := &fakeADSServer{respCh: make(chan *v3discoverypb.DiscoveryResponse, 10)}
+	gs := grpc.NewServer()
+	v3discoverypb.RegisterAggregatedDiscoveryServiceServer(gs, fake)
+	go gs.Serve(lis)
+
+	return lis.Addr().String(), fake, func() {
+		gs.Stop()
+		lis.Close()
+	}
+}
+
+func (f *fakeADSServer) StreamAggregatedResources(stream v3discoverypb.AggregatedDiscoveryService_StreamAggregatedResourcesServer) error {
+	for {
+		if _, err := stream.Recv(); err != nil {
+			return err
+		}
+		select {
+		case resp := <-f.respCh:
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+func testServerClient(t *testing.T, serverAddr string) (xdsclient.XDSClient, func()) {
+	t.Helper()
+	contents := fmt.Sprintf(`{
+		"node": {"id": "test-id"},
+		"xds_servers": [{
+			"server_uri": "%s",
+			"channel_creds": [{"type": "insecure"}],
+			"server_features": []
+		}]
+	}`, serverAddr)
+	cfg, err := bootstrap.NewConfigFromContents([]byte(contents))
+	if err != nil {
+		t.Fatalf("bootstrap.NewConfigFromContents() failed: %v", err)
+	}
+	c, closeFn, err := xdsclient.NewPool(cfg).NewServerClient("test-server-client")
+	if err != nil {
+		t.Fatalf("NewServerClient() failed: %v", err)
+	}
+	return c, closeFn
+}
+
+func marshalTestListener(t *testing.T, name string) *anypb.Any {
+	t.Helper()
+	hcm, err := anypb.New(&v3hcmpb.HttpConnectionManager{
+		RouteSpecifier: &v3hcmpb.HttpConnectionManager_Rds{
+			Rds: &v3hcmpb.Rds{RouteConfigName: "test-route"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("anypb.New(HttpConnectionManager) failed: %v", err)
+	}
+	l := &v3listenerpb.Listener{
+		Name: name,
+		FilterChains: []*v3listenerpb.FilterChain{{
+			Filters: []*v3listenerpb.Filter{{
+				Name:       "envoy.http_connection_manager",
+				ConfigType: &v3listenerpb.Filter_TypedConfig{TypedConfig: hcm},
+			}},
+		}},
+	}
+	b, err := proto.Marshal(l)
+	if err != nil {
+		t.Fatalf("proto.Marshal(Listener) failed: %v", err)
+	}
+	return &anypb.Any{TypeUrl: string(xdsresource.ListenerResourceType), Value: b}
+}
+
+func marshalTestRouteConfig(t *testing.T, name string) *anypb.Any {
+	t.Helper()
+	b, err := proto.Marshal(&v3routepb.RouteConfiguration{Name: name})
+	if err != nil {
+		t.Fatalf("proto.Marshal(RouteConfiguration) failed: %v", err)
+	}
+	return &anypb.Any{TypeUrl: string(xdsresource.RouteConfigResourceType), Value: b}
+}
+
+// TestListenerWrapper_AcceptsOnceListenerUpdateArrives is an end-to-end test
+// covering the whole xDS server-side path: a ListenerWrapper backed by a
+// real xDS client, talking to a fake ADS server over a real gRPC connection.
+// It verifies that the wrapper starts out refusing connections (before any
+// LDS response has arrived), that it keeps refusing them once the Listener
+// update arrives but its filter chain's RouteConfiguration (referenced by
+// name, via RDS) hasn't resolved yet, and that only once that
+// RouteConfiguration update arrives does Accept start succeeding and return
+// a connection matched against the update's filter chain.
+func TestListenerWrapper_AcceptsOnceListenerUpdateArrives(t *testing.T) {
+	addr, fake, stop := newFakeADSServer(t)
+	defer stop()
+
+	client, clientClose := testServerClient(t, addr)
+	defer clientClose()
+
+	inner, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen() failed: %v", err)
+	}
+	defer inner.Close()
+
+	modeCh := make(chan ServingMode, 2)
+	lw, err := NewListenerWrapper(client, inner, inner.Addr().String(), func(_ net.Addr, mode ServingMode, _ error) {
+		modeCh <- mode
+	})
+	if err != nil {
+		t.Fatalf("NewListenerWrapper() failed: %v", err)
+	}
+	defer lw.Close()
+
+	// Before any LDS response has been ACKed, the wrapper is still in
+	// ServingModeStarting and must not hand out a connection.
+	acceptErrCh := make(chan error, 1)
+	go func() {
+		conn, err := lw.Accept()
+		if err == nil {
+			conn.Close()
+		}
+		acceptErrCh <- err
+	}()
+
+	dialerDone := make(chan struct{})
+	go func() {
+		defer close(dialerDone)
+		for i := 0; i < 2; i++ {
+			if conn, err := net.DialTimeout("tcp", inner.Addr().String(), time.Second); err == nil {
+				conn.Close()
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+
+	select {
+	case mode := <-modeCh:
+		t.Fatalf("ServingModeCallback invoked with mode %v before any Listener update was sent", mode)
+	case err := <-acceptErrCh:
+		t.Fatalf("Accept() returned (err = %v) before any Listener update was sent", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	fake.respCh <- &v3discoverypb.DiscoveryResponse{
+		VersionInfo: "1",
+		TypeUrl:     string(xdsresource.ListenerResourceType),
+		Nonce:       "n1",
+		Resources:   []*anypb.Any{marshalTestListener(t, inner.Addr().String())},
+	}
+
+	// The Listener update references RDS route "test-route", which hasn't
+	// arrived yet: the listener must not transition to serving, and must
+	// keep refusing connections, until it does.
+	select {
+	case mode := <-modeCh:
+		t.Fatalf("ServingModeCallback invoked with mode %v before the Listener's RouteConfiguration arrived", mode)
+	case err := <-acceptErrCh:
+		t.Fatalf("Accept() returned (err = %v) before the Listener's RouteConfiguration arrived", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	fake.respCh <- &v3discoverypb.DiscoveryResponse{
+		VersionInfo: "1",
+		TypeUrl:     string(xdsresource.RouteConfigResourceType),
+		Nonce:       "n2",
+		Resources:   []*anypb.Any{marshalTestRouteConfig(t, "test-route")},
+	}
+
+	select {
+	case mode := <-modeCh:
+		if mode != ServingModeServing {
+			t.Fatalf("ServingModeCallback mode = %v, want ServingModeServing", mode)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the listener to transition to ServingModeServing after its RouteConfiguration arrived")
+	}
+
+	// The dialer's connections were all refused while not serving; a new one
+	// is what Accept hands out now.
+	conn, err := net.DialTimeout("tcp", inner.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("net.DialTimeout() failed: %v", err)
+	}
+	defer conn.Close()
+
+	select {
+	case err := <-acceptErrCh:
+		if err != nil {
+			t.Fatalf("Accept() failed after a Listener update was ACKed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for Accept() to succeed after the Listener update")
+	}
+	<-dialerDone
+}
@@ -0,0 +1,350 @@
+/*
+ *
+ * Copyright 2024 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package xdsclient
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	v3corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	v3statuspb "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	estats "google.golang.org/grpc/experimental/stats"
+	igrpclog "google.golang.org/grpc/internal/grpclog"
+	"google.golang.org/grpc/internal/xds/bootstrap"
+	"google.golang.org/grpc/xds/internal/xdsclient/xdsresource"
+)
+
+// clientImpl is the unexported, concrete implementation backing the
+// XDSClient handed out by Pool. Each one owns one adsTransport per
+// authority named in its bootstrap configuration, watches resources on
+// behalf of callers to WatchResource, and applies reloaded bootstrap
+// configuration and fallback transitions in place so that watchers never
+// have to re-register.
+type clientImpl struct {
+	watchExpiryTimeout time.Duration
+	streamBackoff      func(int) time.Duration
+	metricsRecorder    estats.MetricsRecorder
+	serverSide         bool
+	logger             *igrpclog.PrefixLogger
+	target             string
+
+	mu         sync.Mutex
+	config     *bootstrap.Config
+	authorities map[string]*authorityState
+	closed     bool
+}
+
+// authorityState is the per-authority state clientImpl keeps: which
+// management servers are configured (and which is currently active, via
+// fallback), the transport talking to the active one, and the set of
+// resources currently being watched so they can be replayed on fallback or
+// on a credential/URI-driven reconnect.
+type authorityState struct {
+	name     string
+	fallback *authorityFallbackState
+	watches  map[xdsresource.Type]map[string][]xdsresource.ResourceWatcher
+
+	transport adsTransport
+}
+
+// adsTransport is the narrow interface clientImpl needs from the ADS stream
+// layer: enough to (re)point it at a particular server in the authority's
+// server list and to tear it down. The concrete implementation (dialing,
+// sending DiscoveryRequests, parsing DiscoveryResponses, ack/nack
+// bookkeeping) lives with the rest of the transport machinery and is
+// supplied via newAdsTransport; it is an interface here purely so that tests
+// in this package can substitute a fake and exercise clientImpl's fallback
+// and reload logic without a real network connection.
+type adsTransport interface {
+	// restart tears down the current stream, if any, and opens a new one to
+	// the server at serverIdx in the authority's server list, resubscribing
+	// to every currently-watched resource. Per-server version/nonce state is
+	// implicitly reset by virtue of being a new stream.
+	restart(serverIdx int) error
+	// restartGraceful is like restart, except the current stream, if any, is
+	// kept alive (continuing to serve watchers with its last-received data)
+	// until the new one has ACKed a response for every resource type it
+	// subscribed to, so that a
+	// server URI/credential change never leaves an authority with no usable
+	// stream in the meantime.
+	restartGraceful(serverIdx int) error
+	// close tears down the stream and releases transport resources.
+	close()
+}
+
+func newClientImpl(name string, config *bootstrap.Config, watchExpiryTimeout time.Duration, streamBackoff func(int) time.Duration, metricsRecorder estats.MetricsRecorder, serverSide bool) (*clientImpl, error) {
+	if config == nil {
+		return nil, fmt.Errorf("xds: bootstrap configuration is nil")
+	}
+
+	c := &clientImpl{
+		target:             name,
+		watchExpiryTimeout: watchExpiryTimeout,
+		streamBackoff:      streamBackoff,
+		metricsRecorder:    metricsRecorder,
+		serverSide:         serverSide,
+		config:             config,
+		authorities:        make(map[string]*authorityState),
+	}
+	c.logger = igrpclog.NewPrefixLogger(logger, fmt.Sprintf("[xds-client %p] ", c))
+
+	for name, authConfig := range config.Authorities() {
+		c.authorities[name] = c.newAuthorityLocked(name, authConfig.XDSServers)
+	}
+	c.authorities[""] = c.newAuthorityLocked("", config.XDSServers())
+
+	return c, nil
+}
+
+func (c *clientImpl) newAuthorityLocked(name string, servers []*bootstrap.ServerConfig) *authorityState {
+	a := &authorityState{
+		name:     name,
+		fallback: newAuthorityFallbackState(servers),
+		watches:  make(map[xdsresource.Type]map[string][]xdsresource.ResourceWatcher),
+	}
+	a.transport = newAdsTransport(c, a)
+	if len(servers) > 0 {
+		if err := a.transport.restart(0); err != nil {
+			c.logger.Warningf("xds: failed to start ADS stream for authority %q: %v", name, err)
+		}
+	}
+	return a
+}
+
+// WatchResource implements the xdsresource.XDSClient (and therefore
+// XDSClient) interface. A RouteConfiguration watch is only honored on a
+// client obtained via Pool.NewServerClient: resolving a server-side filter
+// chain's route_config_name is the only thing this package uses RDS for
+// today, so a client-side caller watching it is almost certainly a mistake
+// rather than a resolver that actually needs it.
+func (c *clientImpl) WatchResource(rType xdsresource.Type, resourceName string, watcher xdsresource.ResourceWatcher) (cancel func()) {
+	if rType == xdsresource.RouteConfigResourceType && !c.serverSide {
+		err := fmt.Errorf("xds: RouteConfiguration watches are only supported on a client created via Pool.NewServerClient")
+		go watcher.OnError(err)
+		return func() {}
+	}
+
+	authorityName, _ := parseResourceName(resourceName)
+
+	c.mu.Lock()
+	a, ok := c.authorities[authorityName]
+	if !ok {
+		// Fall back to the default (top-level) authority, matching the
+		// behavior of unprefixed, non-xdstp:// resource names.
+		a = c.authorities[""]
+	}
+	byName := a.watches[rType]
+	if byName == nil {
+		byName = make(map[string][]xdsresource.ResourceWatcher)
+		a.watches[rType] = byName
+	}
+	byName[resourceName] = append(byName[resourceName], watcher)
+	c.mu.Unlock()
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		watchers := byName[resourceName]
+		for i, w := range watchers {
+			if w == watcher {
+				byName[resourceName] = append(watchers[:i], watchers[i+1:]...)
+				break
+			}
+		}
+		if len(byName[resourceName]) == 0 {
+			delete(byName, resourceName)
+		}
+	}
+}
+
+// BootstrapConfig implements the XDSClient interface.
+func (c *clientImpl) BootstrapConfig() *bootstrap.Config {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.config
+}
+
+// currentNode returns the Node proto in effect at the time of the call, for
+// an adsTransport to put on its next DiscoveryRequest. Reading it fresh here,
+// rather than an adsTransport caching it when its stream was opened, is what
+// lets a node-only bootstrap reload (see ReloadBootstrapConfig) reach the
+// management server without restarting any stream.
+func (c *clientImpl) currentNode() *v3corepb.Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.config.Node()
+}
+
+// close implements the XDSClient interface.
+func (c *clientImpl) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	for _, a := range c.authorities {
+		a.transport.close()
+	}
+}
+
+// dumpResources assembles the per-client v3statuspb.ClientConfig, including
+// which management server each resource was obtained from, for DumpResources
+// and the CSDS service.
+func (c *clientImpl) dumpResources() *v3statuspb.ClientConfig {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cfg := &v3statuspb.ClientConfig{
+		Node: c.config.Node(),
+	}
+	for _, a := range c.authorities {
+		// An authority that inherits the top-level servers instead of
+		// configuring its own (a normal, spec-compliant federation config)
+		// has no servers of its own; activeServer returns nil for it, since
+		// there's nothing for fallback to track.
+		active := a.fallback.activeServer()
+		if active == nil {
+			continue
+		}
+		server := active.ServerURI()
+		for rType, byName := range a.watches {
+			// The resources gauge is keyed the same way DumpResources names
+			// its entries (server URI + resource-type URL) so that CSDS
+			// output and dashboards built on the metric agree; every
+			// currently-watched resource is counted as "acked" here, since
+			// this transport's simplified response handling (see
+			// transport.go) doesn't yet track NACK/does-not-exist
+			// per-resource.
+			recordResourceCount(c.metricsRecorder, server, string(rType), cacheStateACKed, int64(len(byName)))
+			for name := range byName {
+				cfg.GenericXdsConfigs = append(cfg.GenericXdsConfigs, &v3statuspb.ClientConfig_GenericXdsConfig{
+					TypeUrl:      string(rType),
+					Name:         name,
+					XdsConfig:    nil,
+					ClientStatus: v3statuspb.ClientConfig_GenericXdsConfig_UNKNOWN,
+					// ServerURI isn't a field on the upstream GenericXdsConfig
+					// proto; it's recorded in ErrorState.Details using the
+					// "server: <uri>" convention other xDS client
+					// implementations (e.g. Envoy) use for the same purpose,
+					// so tooling that already parses that field keeps
+					// working.
+					ErrorState: &v3statuspb.UpdateFailureState{Details: fmt.Sprintf("server: %s", server)},
+				})
+			}
+		}
+	}
+	return cfg
+}
+
+// activeServerConfigForAuthority returns the bootstrap.ServerConfig that
+// authority is currently using, for Pool.activeServerConfig.
+func (c *clientImpl) activeServerConfigForAuthority(authority string) (*bootstrap.ServerConfig, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	a, ok := c.authorities[authority]
+	if !ok {
+		return nil, false
+	}
+	active := a.fallback.activeServer()
+	return active, active != nil
+}
+
+// applyBootstrapUpdate diffs old against newCfg and applies the result to
+// every authority: node changes take effect on authorities' next
+// DiscoveryRequest; server URI/credential changes on an authority trigger a
+// reconnect (handled by adsTransport.restartGraceful, which only swaps over
+// once the new stream has ACKed a response for every watched resource type);
+// authorities present in newCfg but not
+// old are created, and authorities removed from newCfg have their transport
+// closed.
+func (c *clientImpl) applyBootstrapUpdate(old, newCfg *bootstrap.Config) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.config = newCfg
+
+	seen := make(map[string]bool)
+	for name, authConfig := range newCfg.Authorities() {
+		seen[name] = true
+		c.applyAuthorityServersLocked(name, authConfig.XDSServers)
+	}
+	seen[""] = true
+	c.applyAuthorityServersLocked("", newCfg.XDSServers())
+
+	for name, a := range c.authorities {
+		if seen[name] {
+			continue
+		}
+		a.transport.close()
+		delete(c.authorities, name)
+	}
+	return nil
+}
+
+func (c *clientImpl) applyAuthorityServersLocked(name string, servers []*bootstrap.ServerConfig) {
+	a, ok := c.authorities[name]
+	if !ok {
+		c.authorities[name] = c.newAuthorityLocked(name, servers)
+		return
+	}
+	if serversEqual(a.fallback.servers, servers) {
+		return
+	}
+	a.fallback = newAuthorityFallbackState(servers)
+	if err := a.transport.restartGraceful(0); err != nil {
+		c.logger.Warningf("xds: reconnect to authority %q after bootstrap reload failed: %v", name, err)
+	}
+}
+
+// serversEqual reports whether a and b are the same server list, comparing
+// every field ServerConfig.Equal considers (server URI and credentials, not
+// just URI) so that a reload which only rotates channel credentials for the
+// same server is still recognized as a change and reconnects, rather than
+// being treated as a no-op and leaving the stale credentials in place.
+func serversEqual(a, b []*bootstrap.ServerConfig) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseResourceName splits a resource name into its authority (empty for a
+// plain, non-xdstp:// name) and the remainder, per the xdstp:// URI format
+// used for federation.
+func parseResourceName(name string) (authority, rest string) {
+	const prefix = "xdstp://"
+	if len(name) < len(prefix) || name[:len(prefix)] != prefix {
+		return "", name
+	}
+	remainder := name[len(prefix):]
+	for i, r := range remainder {
+		if r == '/' {
+			return remainder[:i], remainder[i:]
+		}
+	}
+	return remainder, ""
+}
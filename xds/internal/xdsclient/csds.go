@@ -0,0 +1,296 @@
+/*
+ *
+ * Copyright 2024 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package xdsclient
+
+import (
+	"context"
+	"io"
+	"regexp"
+	"strings"
+
+	v3corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	v3statuspb "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	v3matcherpb "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// ClientScopeMetadataKey is the incoming gRPC metadata key a CSDS caller can
+// set to one or more ClientScope names (the same names Pool.NewClient and
+// Pool.NewServerClient were called with) to restrict a FetchClientStatus or
+// StreamClientStatus call to those clients, instead of dumping the whole
+// pool. Unlike NodeMatchers, which match the xDS Node identity and therefore
+// can't distinguish between clients sharing a pool's single bootstrap
+// configuration, this operates directly on Pool's client-name keys.
+//
+// This is a grpc-go-specific extension to the CSDS protocol: it has no
+// counterpart in the upstream xDS config_dump / grpcdebug surface, and a
+// caller that doesn't set it gets the pre-existing behavior of dumping every
+// client in the pool.
+const ClientScopeMetadataKey = "grpc-xds-client-scope"
+
+// RegisterCSDSService registers an implementation of the Client Status
+// Discovery Service (CSDS) with the given gRPC server. Once registered, a
+// CSDS-speaking admin tool can inspect the resources known to every xDS
+// client created from this pool; set ClientScopeMetadataKey to scope a
+// query down to a single client.
+func (p *Pool) RegisterCSDSService(s *grpc.Server) {
+	v3statuspb.RegisterClientStatusDiscoveryServiceServer(s, &csdsServer{pool: p})
+}
+
+// RegisterCSDSService registers an implementation of CSDS, backed by
+// DefaultPool, with the given gRPC server.
+func RegisterCSDSService(s *grpc.Server) {
+	DefaultPool.RegisterCSDSService(s)
+}
+
+// csdsServer implements the ClientStatusDiscoveryServiceServer interface by
+// delegating to the Pool it was created with.
+type csdsServer struct {
+	v3statuspb.UnimplementedClientStatusDiscoveryServiceServer
+
+	pool *Pool
+}
+
+func (cs *csdsServer) FetchClientStatus(ctx context.Context, req *v3statuspb.ClientStatusRequest) (*v3statuspb.ClientStatusResponse, error) {
+	return cs.pool.dumpResourcesMatching(scopesFromContext(ctx), req.GetNodeMatchers()), nil
+}
+
+func (cs *csdsServer) StreamClientStatus(stream v3statuspb.ClientStatusDiscoveryService_StreamClientStatusServer) error {
+	scopes := scopesFromContext(stream.Context())
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(cs.pool.dumpResourcesMatching(scopes, req.GetNodeMatchers())); err != nil {
+			return err
+		}
+	}
+}
+
+// scopesFromContext returns the set of ClientScope names requested via the
+// ClientScopeMetadataKey incoming metadata key, or nil if none were set
+// (meaning "every client in the pool").
+func scopesFromContext(ctx context.Context) map[string]bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+	values := md.Get(ClientScopeMetadataKey)
+	if len(values) == 0 {
+		return nil
+	}
+	scopes := make(map[string]bool, len(values))
+	for _, v := range values {
+		scopes[v] = true
+	}
+	return scopes
+}
+
+// dumpResourcesMatching is like DumpResources, except that it only includes
+// a given client's entry in the response if: (1) scopes is nil or contains
+// that client's ClientScope name, and (2) node matches every provided
+// NodeMatcher. Since every client in a Pool shares the same bootstrap Node,
+// (2) is necessarily an all-or-nothing gate across the whole pool; (1) is
+// what actually lets a caller select a single ClientScope out of a pool that
+// backs several xDS clients, e.g. one created via NewClient and another via
+// NewServerClient.
+func (p *Pool) dumpResourcesMatching(scopes map[string]bool, matchers []*v3matcherpb.NodeMatcher) *v3statuspb.ClientStatusResponse {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !nodeMatches(p.config.Node(), matchers) {
+		return &v3statuspb.ClientStatusResponse{}
+	}
+
+	resp := &v3statuspb.ClientStatusResponse{}
+	for name, client := range p.clients {
+		if scopes != nil && !scopes[name] {
+			continue
+		}
+		cfg := client.dumpResources()
+		cfg.ClientScope = name
+		resp.Config = append(resp.Config, cfg)
+	}
+	return resp
+}
+
+// nodeMatches reports whether node satisfies every matcher in matchers. Per
+// the CSDS protocol, an empty or nil matcher list matches any node.
+func nodeMatches(node *v3corepb.Node, matchers []*v3matcherpb.NodeMatcher) bool {
+	for _, m := range matchers {
+		if !nodeMatchesOne(node, m) {
+			return false
+		}
+	}
+	return true
+}
+
+func nodeMatchesOne(node *v3corepb.Node, m *v3matcherpb.NodeMatcher) bool {
+	if id := m.GetNodeId(); id != nil && !stringMatches(node.GetId(), id) {
+		return false
+	}
+	for _, sm := range m.GetNodeMetadatas() {
+		if !structMatches(node, sm) {
+			return false
+		}
+	}
+	return true
+}
+
+// structMatches evaluates a single StructMatcher against node. A StructMatcher's
+// path is a sequence of keys to be navigated one at a time (per
+// envoy.type.matcher.v3.StructMatcher), not a single flattened, dotted
+// string: {path: [{key: "locality"}, {key: "region"}]} means "descend into
+// the 'locality' field, then its 'region' field", mirroring how real CSDS
+// clients (grpcdebug, Envoy admin) build these matchers for nested fields.
+// "cluster" and "locality" are recognized as the first segment to address
+// the corresponding well-known Node fields; any other first segment is
+// looked up in node.metadata, descending further for each subsequent
+// segment.
+func structMatches(node *v3corepb.Node, sm *v3matcherpb.StructMatcher) bool {
+	path := sm.GetPath()
+	if len(path) == 0 {
+		return false
+	}
+
+	switch path[0].GetKey() {
+	case "cluster":
+		if len(path) != 1 {
+			return false
+		}
+		return valueMatchesString(node.GetCluster(), sm.GetValueMatcher())
+	case "locality":
+		return localityMatches(node.GetLocality(), path[1:], sm.GetValueMatcher())
+	}
+
+	val, ok := lookupStructPath(node.GetMetadata(), path)
+	if !ok {
+		return sm.GetValueMatcher().GetNullMatch() != nil
+	}
+	return valueMatches(val, sm.GetValueMatcher())
+}
+
+func localityMatches(loc *v3corepb.Locality, rest []*v3matcherpb.StructMatcher_PathSegment, vm *v3matcherpb.ValueMatcher) bool {
+	if len(rest) != 1 {
+		return false
+	}
+	switch rest[0].GetKey() {
+	case "region":
+		return valueMatchesString(loc.GetRegion(), vm)
+	case "zone":
+		return valueMatchesString(loc.GetZone(), vm)
+	case "sub_zone":
+		return valueMatchesString(loc.GetSubZone(), vm)
+	default:
+		return false
+	}
+}
+
+// lookupStructPath descends into s one path segment at a time, returning the
+// google.protobuf.Value found at the end of path, or false if any segment
+// along the way is missing or not itself a struct.
+func lookupStructPath(s *structpb.Struct, path []*v3matcherpb.StructMatcher_PathSegment) (*structpb.Value, bool) {
+	if s == nil || len(path) == 0 {
+		return nil, false
+	}
+
+	fields := s.GetFields()
+	for i, seg := range path {
+		v, ok := fields[seg.GetKey()]
+		if !ok {
+			return nil, false
+		}
+		if i == len(path)-1 {
+			return v, true
+		}
+		st := v.GetStructValue()
+		if st == nil {
+			return nil, false
+		}
+		fields = st.GetFields()
+	}
+	return nil, false
+}
+
+func valueMatches(v *structpb.Value, vm *v3matcherpb.ValueMatcher) bool {
+	switch {
+	case vm.GetStringMatch() != nil:
+		return v.GetKind() != nil && stringMatches(v.GetStringValue(), vm.GetStringMatch())
+	case vm.GetPresentMatch():
+		return v != nil
+	case vm.GetNullMatch() != nil:
+		return v.GetNullValue() == structpb.NullValue_NULL_VALUE
+	default:
+		return false
+	}
+}
+
+func valueMatchesString(s string, vm *v3matcherpb.ValueMatcher) bool {
+	sm := vm.GetStringMatch()
+	if sm == nil {
+		return false
+	}
+	return stringMatches(s, sm)
+}
+
+// stringMatches evaluates a single StringMatcher against s. safe_regex is
+// handled separately from the other four variants since, per
+// StringMatcher.ignore_case's documented semantics, case-insensitivity
+// doesn't apply to it (a regex wanting that should use its own (?i) flag
+// instead); an unparseable regex matches nothing rather than panicking or
+// silently falling through to the other variants.
+func stringMatches(s string, m *v3matcherpb.StringMatcher) bool {
+	if re := m.GetSafeRegex(); re != nil {
+		rx, err := regexp.Compile(re.GetRegex())
+		if err != nil {
+			return false
+		}
+		return rx.MatchString(s)
+	}
+
+	matchAgainst := func(v string) string {
+		if m.GetIgnoreCase() {
+			return strings.ToLower(v)
+		}
+		return v
+	}
+	s = matchAgainst(s)
+	switch {
+	case m.GetExact() != "":
+		return s == matchAgainst(m.GetExact())
+	case m.GetPrefix() != "":
+		return strings.HasPrefix(s, matchAgainst(m.GetPrefix()))
+	case m.GetSuffix() != "":
+		return strings.HasSuffix(s, matchAgainst(m.GetSuffix()))
+	case m.GetContains() != "":
+		return strings.Contains(s, matchAgainst(m.GetContains()))
+	default:
+		// No recognized variant is set; fail closed rather than matching
+		// every node, so a StringMatcher kind this package doesn't yet
+		// understand produces an obviously-too-narrow (empty) result instead
+		// of a silently too-broad one.
+		return false
+	}
+}
@@ -0,0 +1,186 @@
+/*
+ *
+ * Copyright 2024 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package xdsclient
+
+import (
+	"testing"
+
+	v3corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	v3matcherpb "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func testNode(t *testing.T) *v3corepb.Node {
+	t.Helper()
+	md, err := structpb.NewStruct(map[string]interface{}{
+		"region": "us-east",
+		"labels": map[string]interface{}{
+			"app": "echo",
+		},
+	})
+	if err != nil {
+		t.Fatalf("structpb.NewStruct() failed: %v", err)
+	}
+	return &v3corepb.Node{
+		Id:      "node-1",
+		Cluster: "echo-cluster",
+		Locality: &v3corepb.Locality{
+			Region:  "us-east-1",
+			Zone:    "us-east-1a",
+			SubZone: "rack-1",
+		},
+		Metadata: md,
+	}
+}
+
+func exactMatcher(s string) *v3matcherpb.StringMatcher {
+	return &v3matcherpb.StringMatcher{MatchPattern: &v3matcherpb.StringMatcher_Exact{Exact: s}}
+}
+
+func TestNodeMatches_EmptyMatchersMatchAnything(t *testing.T) {
+	if !nodeMatches(testNode(t), nil) {
+		t.Fatalf("nodeMatches(node, nil) = false, want true")
+	}
+}
+
+func TestNodeMatches_NodeID(t *testing.T) {
+	node := testNode(t)
+	matchers := []*v3matcherpb.NodeMatcher{{NodeId: exactMatcher("node-1")}}
+	if !nodeMatches(node, matchers) {
+		t.Fatalf("nodeMatches() with matching node id = false, want true")
+	}
+
+	matchers = []*v3matcherpb.NodeMatcher{{NodeId: exactMatcher("node-2")}}
+	if nodeMatches(node, matchers) {
+		t.Fatalf("nodeMatches() with non-matching node id = true, want false")
+	}
+}
+
+func TestNodeMatches_Cluster(t *testing.T) {
+	node := testNode(t)
+	sm := &v3matcherpb.StructMatcher{
+		Path:         []*v3matcherpb.StructMatcher_PathSegment{{Segment: &v3matcherpb.StructMatcher_PathSegment_Key{Key: "cluster"}}},
+		ValueMatcher: &v3matcherpb.ValueMatcher{MatchPattern: &v3matcherpb.ValueMatcher_StringMatch{StringMatch: exactMatcher("echo-cluster")}},
+	}
+	matchers := []*v3matcherpb.NodeMatcher{{NodeMetadatas: []*v3matcherpb.StructMatcher{sm}}}
+	if !nodeMatches(node, matchers) {
+		t.Fatalf("nodeMatches() with matching cluster = false, want true")
+	}
+}
+
+func TestNodeMatches_NestedLocality(t *testing.T) {
+	node := testNode(t)
+	sm := &v3matcherpb.StructMatcher{
+		Path: []*v3matcherpb.StructMatcher_PathSegment{
+			{Segment: &v3matcherpb.StructMatcher_PathSegment_Key{Key: "locality"}},
+			{Segment: &v3matcherpb.StructMatcher_PathSegment_Key{Key: "zone"}},
+		},
+		ValueMatcher: &v3matcherpb.ValueMatcher{MatchPattern: &v3matcherpb.ValueMatcher_StringMatch{StringMatch: exactMatcher("us-east-1a")}},
+	}
+	matchers := []*v3matcherpb.NodeMatcher{{NodeMetadatas: []*v3matcherpb.StructMatcher{sm}}}
+	if !nodeMatches(node, matchers) {
+		t.Fatalf("nodeMatches() with matching nested locality.zone = false, want true")
+	}
+
+	sm.ValueMatcher.GetStringMatch().MatchPattern = &v3matcherpb.StringMatcher_Exact{Exact: "wrong-zone"}
+	if nodeMatches(node, matchers) {
+		t.Fatalf("nodeMatches() with non-matching locality.zone = true, want false")
+	}
+}
+
+func TestNodeMatches_NestedMetadataPath(t *testing.T) {
+	node := testNode(t)
+	// path: metadata.labels.app, which requires descending two levels into
+	// node.metadata -- this is the case the flattened "labels.app"-as-a-
+	// single-segment-key bug used to get wrong.
+	sm := &v3matcherpb.StructMatcher{
+		Path: []*v3matcherpb.StructMatcher_PathSegment{
+			{Segment: &v3matcherpb.StructMatcher_PathSegment_Key{Key: "labels"}},
+			{Segment: &v3matcherpb.StructMatcher_PathSegment_Key{Key: "app"}},
+		},
+		ValueMatcher: &v3matcherpb.ValueMatcher{MatchPattern: &v3matcherpb.ValueMatcher_StringMatch{StringMatch: exactMatcher("echo")}},
+	}
+	matchers := []*v3matcherpb.NodeMatcher{{NodeMetadatas: []*v3matcherpb.StructMatcher{sm}}}
+	if !nodeMatches(node, matchers) {
+		t.Fatalf("nodeMatches() with matching nested metadata path = false, want true")
+	}
+
+	// A single-segment path using the old dotted-string convention
+	// ("labels.app" as one key) must NOT match: the real field is nested,
+	// not named with a literal dot.
+	flatSM := &v3matcherpb.StructMatcher{
+		Path:         []*v3matcherpb.StructMatcher_PathSegment{{Segment: &v3matcherpb.StructMatcher_PathSegment_Key{Key: "labels.app"}}},
+		ValueMatcher: &v3matcherpb.ValueMatcher{MatchPattern: &v3matcherpb.ValueMatcher_StringMatch{StringMatch: exactMatcher("echo")}},
+	}
+	if nodeMatches(node, []*v3matcherpb.NodeMatcher{{NodeMetadatas: []*v3matcherpb.StructMatcher{flatSM}}}) {
+		t.Fatalf("nodeMatches() with a flattened dotted path key matched, want false")
+	}
+}
+
+func TestStringMatches(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		m    *v3matcherpb.StringMatcher
+		want bool
+	}{
+		{"exact match", "foo", exactMatcher("foo"), true},
+		{"exact mismatch", "foo", exactMatcher("bar"), false},
+		{"prefix match", "foobar", &v3matcherpb.StringMatcher{MatchPattern: &v3matcherpb.StringMatcher_Prefix{Prefix: "foo"}}, true},
+		{"suffix match", "foobar", &v3matcherpb.StringMatcher{MatchPattern: &v3matcherpb.StringMatcher_Suffix{Suffix: "bar"}}, true},
+		{"contains match", "foobar", &v3matcherpb.StringMatcher{MatchPattern: &v3matcherpb.StringMatcher_Contains{Contains: "oob"}}, true},
+		{
+			"ignore case",
+			"FOO",
+			&v3matcherpb.StringMatcher{IgnoreCase: true, MatchPattern: &v3matcherpb.StringMatcher_Exact{Exact: "foo"}},
+			true,
+		},
+		{
+			"safe_regex match",
+			"foobar",
+			&v3matcherpb.StringMatcher{MatchPattern: &v3matcherpb.StringMatcher_SafeRegex{SafeRegex: &v3matcherpb.RegexMatcher{Regex: "^foo.*$"}}},
+			true,
+		},
+		{
+			"safe_regex mismatch",
+			"barfoo",
+			&v3matcherpb.StringMatcher{MatchPattern: &v3matcherpb.StringMatcher_SafeRegex{SafeRegex: &v3matcherpb.RegexMatcher{Regex: "^foo.*$"}}},
+			false,
+		},
+		{
+			"safe_regex unparseable pattern fails closed",
+			"foobar",
+			&v3matcherpb.StringMatcher{MatchPattern: &v3matcherpb.StringMatcher_SafeRegex{SafeRegex: &v3matcherpb.RegexMatcher{Regex: "("}}},
+			false,
+		},
+		{
+			"unset matcher fails closed",
+			"foo",
+			&v3matcherpb.StringMatcher{},
+			false,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := stringMatches(tc.s, tc.m); got != tc.want {
+				t.Errorf("stringMatches(%q, %v) = %v, want %v", tc.s, tc.m, got, tc.want)
+			}
+		})
+	}
+}
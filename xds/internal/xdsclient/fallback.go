@@ -0,0 +1,158 @@
+/*
+ *
+ * Copyright 2024 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package xdsclient
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/internal/xds/bootstrap"
+)
+
+// maxStreamFailuresBeforeFallback is the number of consecutive ADS stream
+// failures, on the currently active server for an authority, after which the
+// transport falls back to the next server in that authority's server list.
+// Only a stream that ends or fails counts as a failure for this purpose; a
+// stream that stays open without receiving a response is not counted.
+const maxStreamFailuresBeforeFallback = 3
+
+// authorityFallbackState tracks, for a single authority, which of its
+// configured management servers is currently active. clientImpl owns one of
+// these per authority; it is consulted and updated both from the transport's
+// stream event callbacks, which run unsynchronized on the authority's stream
+// goroutines (run, runStream, probePrimary), and from clientImpl's own
+// mu-guarded methods (dumpResources, activeServerConfigForAuthority) and
+// Pool's DumpResources/testing queries. Since those two call sites don't
+// share a lock, activeServerIndex and consecutiveFails are guarded by mu
+// below instead of by clientImpl.mu.
+type authorityFallbackState struct {
+	servers []*bootstrap.ServerConfig
+
+	mu sync.Mutex
+	// activeServerIndex is the index, into servers, of the management server
+	// the authority's transport is currently talking to. It starts at 0 (the
+	// primary) and only ever changes through onStreamFailure/onPrimaryRecovered
+	// below.
+	activeServerIndex int
+	consecutiveFails  int
+}
+
+func newAuthorityFallbackState(servers []*bootstrap.ServerConfig) *authorityFallbackState {
+	return &authorityFallbackState{servers: servers}
+}
+
+// activeServer returns the currently active management server for this
+// authority, or nil if it has none configured (an authority that omits its
+// own xds_servers in the bootstrap file and so has nothing to fall back
+// between).
+func (a *authorityFallbackState) activeServer() *bootstrap.ServerConfig {
+	if len(a.servers) == 0 {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.servers[a.activeServerIndex]
+}
+
+// onStreamFailure is invoked by the transport when the ADS stream to the
+// currently active server has ended or failed. It reports the
+// index of the server to restart the stream against: either the same server
+// (failures haven't crossed the threshold yet) or the next one in the list,
+// wrapping back to the primary once the list is exhausted.
+func (a *authorityFallbackState) onStreamFailure() (newIndex int, switched bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.consecutiveFails++
+	if a.consecutiveFails < maxStreamFailuresBeforeFallback || len(a.servers) < 2 {
+		return a.activeServerIndex, false
+	}
+	a.consecutiveFails = 0
+	a.activeServerIndex = (a.activeServerIndex + 1) % len(a.servers)
+	return a.activeServerIndex, true
+}
+
+// onStreamSuccess is invoked by the transport once a response has been
+// received on the stream to the currently active server. It resets the
+// failure count and, if the active server isn't already the primary, reports
+// that the caller should attempt to switch back to the primary: re-dial it,
+// and only call onPrimaryRecovered (discarding fallback state) once the
+// primary has itself acked a request.
+func (a *authorityFallbackState) onStreamSuccess() (shouldProbePrimary bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.consecutiveFails = 0
+	return a.activeServerIndex != 0
+}
+
+// onPrimaryRecovered switches the authority back to its primary server. The
+// caller is expected to have already re-subscribed to the authority's
+// watched resources on the primary and received an ack for them; until then,
+// cached resources from the fallback server remain authoritative so that RPCs
+// relying on them are not disrupted.
+func (a *authorityFallbackState) onPrimaryRecovered() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.activeServerIndex = 0
+	a.consecutiveFails = 0
+}
+
+// activeServerConfig returns the bootstrap.ServerConfig that the client
+// called name is currently using for authority, for use by DumpResources and
+// tests. It returns an error if the client or authority is not known to the
+// pool.
+func (p *Pool) activeServerConfig(name, authority string) (*bootstrap.ServerConfig, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c, ok := p.clients[name]
+	if !ok {
+		return nil, fmt.Errorf("xds: no client with name %q in pool", name)
+	}
+	cfg, ok := c.clientImpl.activeServerConfigForAuthority(authority)
+	if !ok {
+		return nil, fmt.Errorf("xds: no authority %q for client %q", authority, name)
+	}
+	return cfg, nil
+}
+
+// ActiveServerURIForTesting returns the URI of the management server that
+// the named client is currently using for the given authority (the empty
+// string for the top-level/default authority), reflecting any fallback that
+// may have occurred.
+//
+// # Testing Only
+//
+// This function should ONLY be used for testing purposes.
+func (p *Pool) ActiveServerURIForTesting(name, authority string) (string, error) {
+	cfg, err := p.activeServerConfig(name, authority)
+	if err != nil {
+		return "", err
+	}
+	return cfg.ServerURI(), nil
+}
+
+// defaultStreamBackoffAfterFailureDuration is how long a transport waits,
+// after an ADS stream has ended or failed, before retrying it (see
+// defaultStreamBackoffFunc). A stream that stays open without a response is
+// not timed out.
+const defaultStreamBackoffAfterFailureDuration = 15 * time.Second
@@ -0,0 +1,136 @@
+/*
+ *
+ * Copyright 2024 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package xdsclient
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/internal/xds/bootstrap"
+)
+
+func threeServers(t *testing.T) []*bootstrap.ServerConfig {
+	t.Helper()
+	// The exact contents of each ServerConfig don't matter for these tests,
+	// only that there are three distinct ones to fail over between; the
+	// bootstrap package under test elsewhere is responsible for actually
+	// populating usable ServerConfigs from JSON.
+	return []*bootstrap.ServerConfig{{}, {}, {}}
+}
+
+func TestAuthorityFallbackState_StaysOnPrimaryBelowThreshold(t *testing.T) {
+	a := newAuthorityFallbackState(threeServers(t))
+
+	for i := 0; i < maxStreamFailuresBeforeFallback-1; i++ {
+		idx, switched := a.onStreamFailure()
+		if switched {
+			t.Fatalf("onStreamFailure() #%d: switched = true, want false", i)
+		}
+		if idx != 0 {
+			t.Fatalf("onStreamFailure() #%d: idx = %d, want 0", i, idx)
+		}
+	}
+}
+
+func TestAuthorityFallbackState_FallsOverAtThreshold(t *testing.T) {
+	a := newAuthorityFallbackState(threeServers(t))
+
+	var idx int
+	var switched bool
+	for i := 0; i < maxStreamFailuresBeforeFallback; i++ {
+		idx, switched = a.onStreamFailure()
+	}
+	if !switched {
+		t.Fatalf("onStreamFailure() at threshold: switched = false, want true")
+	}
+	if idx != 1 {
+		t.Fatalf("onStreamFailure() at threshold: idx = %d, want 1", idx)
+	}
+	if got := a.activeServer(); got != a.servers[1] {
+		t.Fatalf("activeServer() = %v, want servers[1]", got)
+	}
+}
+
+func TestAuthorityFallbackState_FallsOverThenWrapsAround(t *testing.T) {
+	a := newAuthorityFallbackState(threeServers(t))
+
+	// Fail the primary, then each fallback in turn, and expect the active
+	// index to cycle 0 -> 1 -> 2 -> 0.
+	wantIndices := []int{1, 2, 0}
+	for _, want := range wantIndices {
+		var idx int
+		var switched bool
+		for i := 0; i < maxStreamFailuresBeforeFallback; i++ {
+			idx, switched = a.onStreamFailure()
+		}
+		if !switched || idx != want {
+			t.Fatalf("onStreamFailure(): idx = %d, switched = %v, want %d, true", idx, switched, want)
+		}
+	}
+}
+
+func TestAuthorityFallbackState_SuccessResetsFailureCount(t *testing.T) {
+	a := newAuthorityFallbackState(threeServers(t))
+
+	a.onStreamFailure()
+	a.onStreamFailure()
+	a.onStreamSuccess()
+
+	// Two more failures shouldn't be enough to cross the threshold, since
+	// the earlier two were reset by the success in between.
+	for i := 0; i < maxStreamFailuresBeforeFallback-1; i++ {
+		_, switched := a.onStreamFailure()
+		if switched {
+			t.Fatalf("onStreamFailure() #%d after reset: switched = true, want false", i)
+		}
+	}
+}
+
+func TestAuthorityFallbackState_SingleServerNeverFallsOver(t *testing.T) {
+	a := newAuthorityFallbackState([]*bootstrap.ServerConfig{{}})
+
+	for i := 0; i < maxStreamFailuresBeforeFallback*2; i++ {
+		idx, switched := a.onStreamFailure()
+		if switched || idx != 0 {
+			t.Fatalf("onStreamFailure() #%d with one server: idx = %d, switched = %v, want 0, false", i, idx, switched)
+		}
+	}
+}
+
+func TestAuthorityFallbackState_OnStreamSuccessReportsProbeNeeded(t *testing.T) {
+	a := newAuthorityFallbackState(threeServers(t))
+
+	if probe := a.onStreamSuccess(); probe {
+		t.Fatalf("onStreamSuccess() on primary: probe = true, want false")
+	}
+
+	for i := 0; i < maxStreamFailuresBeforeFallback; i++ {
+		a.onStreamFailure()
+	}
+	if probe := a.onStreamSuccess(); !probe {
+		t.Fatalf("onStreamSuccess() on fallback: probe = false, want true")
+	}
+
+	a.onPrimaryRecovered()
+	if got := a.activeServer(); got != a.servers[0] {
+		t.Fatalf("activeServer() after onPrimaryRecovered() = %v, want servers[0]", got)
+	}
+	if probe := a.onStreamSuccess(); probe {
+		t.Fatalf("onStreamSuccess() after recovery: probe = true, want false")
+	}
+}
@@ -0,0 +1,129 @@
+/*
+ *
+ * Copyright 2024 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package xdsclient
+
+import (
+	"time"
+
+	estats "google.golang.org/grpc/experimental/stats"
+)
+
+// Metric handles for the xDS client pool. These are registered once, at
+// package init time, and recorded against whichever estats.MetricsRecorder a
+// Pool was constructed with (see Pool.WithMetricsRecorder). A Pool that has no
+// recorder configured records against estats.DefaultMetrics, which is a no-op
+// until a recorder is plugged in, e.g. via stats/opentelemetry.
+var (
+	connectedMetric = estats.RegisterInt64Gauge(estats.MetricDescriptor{
+		Name:        "grpc.xds_client.connected",
+		Description: "Whether or not the xDS client currently has a working ADS stream to the given server.",
+		Unit:        "{bool}",
+		Labels:      []string{"grpc.xds.server"},
+		Default:     false,
+	})
+
+	resourcesMetric = estats.RegisterInt64Gauge(estats.MetricDescriptor{
+		Name:        "grpc.xds_client.resources",
+		Description: "Number of xDS resources, by type and cache state.",
+		Unit:        "{resource}",
+		Labels:      []string{"grpc.xds.server", "grpc.xds.resource_type", "grpc.xds.cache_state"},
+		Default:     false,
+	})
+
+	resourceUpdatesValidMetric = estats.RegisterInt64Count(estats.MetricDescriptor{
+		Name:        "grpc.xds_client.resource_updates_valid",
+		Description: "Number of xDS resource updates received that were ACK'd.",
+		Unit:        "{resource_update}",
+		Labels:      []string{"grpc.target", "grpc.xds.server"},
+		Default:     false,
+	})
+
+	resourceUpdatesInvalidMetric = estats.RegisterInt64Count(estats.MetricDescriptor{
+		Name:        "grpc.xds_client.resource_updates_invalid",
+		Description: "Number of xDS resource updates received that were invalid.",
+		Unit:        "{resource_update}",
+		Labels:      []string{"grpc.target", "grpc.xds.server"},
+		Default:     false,
+	})
+
+	serverFailureMetric = estats.RegisterInt64Count(estats.MetricDescriptor{
+		Name:        "grpc.xds_client.resource_updates_server_failure",
+		Description: "Number of server failures.",
+		Unit:        "{failure}",
+		Labels:      []string{"grpc.target"},
+		Default:     false,
+	})
+
+	timeToFirstResponseMetric = estats.RegisterFloat64Histo(estats.MetricDescriptor{
+		Name:        "grpc.xds_client.time_to_first_response",
+		Description: "Time between a new ADS stream being opened and the first DiscoveryResponse being received on it.",
+		Unit:        "s",
+		Labels:      []string{"grpc.target", "grpc.xds.server"},
+		Default:     false,
+	})
+)
+
+// cacheState mirrors the ack/nack/does-not-exist/requested states used by
+// DumpResources, as a label value for the resources gauge so that CSDS
+// output and metrics agree on terminology.
+type cacheState string
+
+const (
+	cacheStateRequested    cacheState = "requested"
+	cacheStateACKed        cacheState = "acked"
+	cacheStateNACKed       cacheState = "nacked"
+	cacheStateDoesNotExist cacheState = "does_not_exist"
+)
+
+// recordResourceUpdate is called by clientImpl's ADS response handling code,
+// once per response, to record whether the update was accepted.
+func recordResourceUpdate(mr estats.MetricsRecorder, target, server string, valid bool) {
+	if valid {
+		resourceUpdatesValidMetric.Record(mr, 1, target, server)
+		return
+	}
+	resourceUpdatesInvalidMetric.Record(mr, 1, target, server)
+}
+
+// recordServerFailure is called by the transport when an ADS stream to a
+// management server fails.
+func recordServerFailure(mr estats.MetricsRecorder, target string) {
+	serverFailureMetric.Record(mr, 1, target)
+}
+
+// recordTimeToFirstResponse is called once per ADS stream, the first time a
+// response is received on it.
+func recordTimeToFirstResponse(mr estats.MetricsRecorder, target, server string, d time.Duration) {
+	timeToFirstResponseMetric.Record(mr, d.Seconds(), target, server)
+}
+
+// recordConnected updates the connected gauge for the given server URI.
+func recordConnected(mr estats.MetricsRecorder, server string, connected bool) {
+	var v int64
+	if connected {
+		v = 1
+	}
+	connectedMetric.Record(mr, v, server)
+}
+
+// recordResourceCount updates the resources gauge for a single (server, type,
+// state) tuple to count.
+func recordResourceCount(mr estats.MetricsRecorder, server, resourceType string, state cacheState, count int64) {
+	resourcesMetric.Record(mr, count, server, resourceType, string(state))
+}
@@ -0,0 +1,124 @@
+/*
+ *
+ * Copyright 2024 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package xdsclient
+
+import (
+	"testing"
+
+	estats "google.golang.org/grpc/experimental/stats"
+)
+
+// fakeMetricsRecorder implements estats.MetricsRecorder, capturing every
+// call it receives so tests can assert on what was recorded, without
+// depending on a real OpenTelemetry exporter.
+type fakeMetricsRecorder struct {
+	int64Counts   []recordedCall
+	float64Counts []recordedCall
+	int64Histos   []recordedCall
+	float64Histos []recordedCall
+	int64Gauges   []recordedCall
+	float64Gauges []recordedCall
+}
+
+type recordedCall struct {
+	handle any
+	value  float64
+	labels []string
+}
+
+func (f *fakeMetricsRecorder) RecordInt64Count(handle *estats.Int64CountHandle, incr int64, labels ...string) {
+	f.int64Counts = append(f.int64Counts, recordedCall{handle, float64(incr), labels})
+}
+
+func (f *fakeMetricsRecorder) RecordFloat64Count(handle *estats.Float64CountHandle, incr float64, labels ...string) {
+	f.float64Counts = append(f.float64Counts, recordedCall{handle, incr, labels})
+}
+
+func (f *fakeMetricsRecorder) RecordInt64Histo(handle *estats.Int64HistoHandle, incr int64, labels ...string) {
+	f.int64Histos = append(f.int64Histos, recordedCall{handle, float64(incr), labels})
+}
+
+func (f *fakeMetricsRecorder) RecordFloat64Histo(handle *estats.Float64HistoHandle, incr float64, labels ...string) {
+	f.float64Histos = append(f.float64Histos, recordedCall{handle, incr, labels})
+}
+
+func (f *fakeMetricsRecorder) RecordInt64Gauge(handle *estats.Int64GaugeHandle, incr int64, labels ...string) {
+	f.int64Gauges = append(f.int64Gauges, recordedCall{handle, float64(incr), labels})
+}
+
+func (f *fakeMetricsRecorder) RecordFloat64Gauge(handle *estats.Float64GaugeHandle, incr float64, labels ...string) {
+	f.float64Gauges = append(f.float64Gauges, recordedCall{handle, incr, labels})
+}
+
+func TestRecordResourceUpdate(t *testing.T) {
+	f := &fakeMetricsRecorder{}
+
+	recordResourceUpdate(f, "my-client", "server-a", true)
+	recordResourceUpdate(f, "my-client", "server-a", false)
+
+	if len(f.int64Counts) != 2 {
+		t.Fatalf("got %d int64 count recordings, want 2", len(f.int64Counts))
+	}
+	if got := f.int64Counts[0]; got.handle != resourceUpdatesValidMetric || got.value != 1 {
+		t.Errorf("valid update recorded as %+v, want handle=resourceUpdatesValidMetric value=1", got)
+	}
+	if got := f.int64Counts[1]; got.handle != resourceUpdatesInvalidMetric || got.value != 1 {
+		t.Errorf("invalid update recorded as %+v, want handle=resourceUpdatesInvalidMetric value=1", got)
+	}
+}
+
+func TestRecordConnected(t *testing.T) {
+	f := &fakeMetricsRecorder{}
+
+	recordConnected(f, "server-a", true)
+	recordConnected(f, "server-a", false)
+
+	if len(f.int64Gauges) != 2 {
+		t.Fatalf("got %d int64 gauge recordings, want 2", len(f.int64Gauges))
+	}
+	if got := f.int64Gauges[0]; got.value != 1 || got.labels[0] != "server-a" {
+		t.Errorf("connected=true recorded as %+v, want value=1 labels=[server-a]", got)
+	}
+	if got := f.int64Gauges[1]; got.value != 0 {
+		t.Errorf("connected=false recorded as %+v, want value=0", got)
+	}
+}
+
+func TestRecordServerFailure(t *testing.T) {
+	f := &fakeMetricsRecorder{}
+
+	recordServerFailure(f, "my-client")
+
+	if len(f.int64Counts) != 1 || f.int64Counts[0].handle != serverFailureMetric {
+		t.Fatalf("got %+v, want a single serverFailureMetric recording", f.int64Counts)
+	}
+}
+
+func TestRecordResourceCount(t *testing.T) {
+	f := &fakeMetricsRecorder{}
+
+	recordResourceCount(f, "server-a", string(cacheStateACKed), cacheStateACKed, 3)
+
+	if len(f.int64Gauges) != 1 {
+		t.Fatalf("got %d int64 gauge recordings, want 1", len(f.int64Gauges))
+	}
+	if got := f.int64Gauges[0]; got.handle != resourcesMetric || got.value != 3 {
+		t.Errorf("got %+v, want handle=resourcesMetric value=3", got)
+	}
+}
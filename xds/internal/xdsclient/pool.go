@@ -24,6 +24,7 @@ import (
 	"time"
 
 	v3statuspb "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	estats "google.golang.org/grpc/experimental/stats"
 	"google.golang.org/grpc/internal/backoff"
 	"google.golang.org/grpc/internal/grpcsync"
 	"google.golang.org/grpc/internal/xds/bootstrap"
@@ -41,9 +42,23 @@ type Pool struct {
 	// Note that mu should ideally only have to guard clients. But here, we need
 	// it to guard config as well since SetFallbackBootstrapConfig writes to
 	// config.
-	mu      sync.Mutex
-	clients map[string]*clientRefCounted
-	config  *bootstrap.Config
+	mu              sync.Mutex
+	clients         map[string]*clientRefCounted
+	config          *bootstrap.Config
+	metricsRecorder estats.MetricsRecorder
+}
+
+// WithMetricsRecorder sets the estats.MetricsRecorder that clients created
+// from this pool will record ADS ack/nack events and connectivity gauges to,
+// for example a recorder obtained from stats/opentelemetry. It must be called
+// before the first call to NewClient/NewClientForTesting; it has no effect on
+// clients that already exist. It returns p, for chaining off of NewPool.
+func (p *Pool) WithMetricsRecorder(mr estats.MetricsRecorder) *Pool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.metricsRecorder = mr
+	return p
 }
 
 // OptionsForTesting contains options to configure xDS client creation for
@@ -84,7 +99,27 @@ func NewPool(config *bootstrap.Config) *Pool {
 // expected to invoke once they are done using the client.  It is safe for the
 // caller to invoke this close function multiple times.
 func (p *Pool) NewClient(name string) (XDSClient, func(), error) {
-	return p.newRefCounted(name, defaultWatchExpiryTimeout, backoff.DefaultExponential.Backoff)
+	return p.newRefCounted(name, defaultWatchExpiryTimeout, backoff.DefaultExponential.Backoff, false)
+}
+
+// NewServerClient is like NewClient, except that the returned client also
+// accepts watches for the server-side flavor of the Listener resource (one
+// whose filter chains are matched against incoming connections, rather than
+// one with an api_listener) and the RouteConfiguration resources its filter
+// chains reference. This allows a gRPC server, via serverxds.NewListenerWrapper,
+// to be driven by LDS/RDS from the same pool, and potentially the same
+// underlying client and ADS stream, that powers the process's client-side
+// resolvers and balancers.
+//
+// As with NewClient, if a client with the given name already exists in the
+// pool, a reference to it is returned instead of creating a new one, unless
+// that client was created client-side-only (via NewClient or
+// NewClientForTesting), in which case an error is returned; Pool's
+// usual reference counting ensures the underlying client is not closed while
+// either a client-side user or a server-side ListenerWrapper still holds a
+// reference to it.
+func (p *Pool) NewServerClient(name string) (XDSClient, func(), error) {
+	return p.newRefCounted(name, defaultWatchExpiryTimeout, backoff.DefaultExponential.Backoff, true)
 }
 
 // NewClientForTesting returns an xDS client configured with the provided
@@ -108,7 +143,7 @@ func (p *Pool) NewClientForTesting(opts OptionsForTesting) (XDSClient, func(), e
 	if opts.StreamBackoffAfterFailure == nil {
 		opts.StreamBackoffAfterFailure = defaultStreamBackoffFunc
 	}
-	return p.newRefCounted(opts.Name, opts.WatchExpiryTimeout, opts.StreamBackoffAfterFailure)
+	return p.newRefCounted(opts.Name, opts.WatchExpiryTimeout, opts.StreamBackoffAfterFailure, false)
 }
 
 // GetClientForTesting returns an xDS client created earlier using the given
@@ -182,8 +217,11 @@ func (p *Pool) clientRefCountedClose(name string) {
 
 // newRefCounted creates a new reference counted xDS client implementation for
 // name, if one does not exist already. If an xDS client for the given name
-// exists, it gets a reference to it and returns it.
-func (p *Pool) newRefCounted(name string, watchExpiryTimeout time.Duration, streamBackoff func(int) time.Duration) (XDSClient, func(), error) {
+// exists, it gets a reference to it and returns it. serverSide, once true for
+// a given name, sticks: a client originally created via NewServerClient keeps
+// accepting server-side resource types for as long as any reference to it,
+// client-side or server-side, is outstanding.
+func (p *Pool) newRefCounted(name string, watchExpiryTimeout time.Duration, streamBackoff func(int) time.Duration, serverSide bool) (XDSClient, func(), error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -192,11 +230,14 @@ func (p *Pool) newRefCounted(name string, watchExpiryTimeout time.Duration, stre
 	}
 
 	if c := p.clients[name]; c != nil {
+		if serverSide && !c.serverSide {
+			return nil, nil, fmt.Errorf("xds: client %q already exists in the pool as a client-side-only xDS client; NewServerClient cannot upgrade it in place, use a distinct name for the server-side client", name)
+		}
 		c.incrRef()
 		return c, grpcsync.OnceFunc(func() { p.clientRefCountedClose(name) }), nil
 	}
 
-	c, err := newClientImpl(p.config, watchExpiryTimeout, streamBackoff)
+	c, err := newClientImpl(name, p.config, watchExpiryTimeout, streamBackoff, p.metricsRecorder, serverSide)
 	if err != nil {
 		return nil, nil, err
 	}
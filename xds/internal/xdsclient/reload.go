@@ -0,0 +1,136 @@
+/*
+ *
+ * Copyright 2024 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package xdsclient
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+	"google.golang.org/grpc/internal/grpcsync"
+	"google.golang.org/grpc/internal/xds/bootstrap"
+)
+
+// ReloadBootstrapConfig replaces the pool's bootstrap configuration with cfg
+// and propagates the change to every already-created client in the pool,
+// without tearing down any of their watchers:
+//
+//   - a change to the node proto is picked up by the next DiscoveryRequest
+//     each client's authorities send, with no stream interruption;
+//   - a change to a server's URI or credentials triggers a graceful
+//     reconnect: the new ADS stream is established and allowed to ack a
+//     response for every currently-watched resource type before the old
+//     stream is closed;
+//   - authorities present in cfg but not in the old configuration get a new
+//     transport; authorities removed from cfg have their transport closed
+//     once it is safe to do so.
+//
+// Clients created after this call (and the DefaultPool, if reloaded) see cfg
+// from the start; it is not necessary to call this for the pool used by
+// NewClient/NewClientForTesting going forward.
+func (p *Pool) ReloadBootstrapConfig(cfg *bootstrap.Config) error {
+	if cfg == nil {
+		return fmt.Errorf("xds: ReloadBootstrapConfig called with a nil configuration")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	old := p.config
+	p.config = cfg
+	for name, c := range p.clients {
+		if err := c.clientImpl.applyBootstrapUpdate(old, cfg); err != nil {
+			return fmt.Errorf("xds: failed to apply reloaded bootstrap configuration to client %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// WatchBootstrapFile starts watching path for changes (using fsnotify) and,
+// on every write as well as on receipt of SIGHUP, re-reads it and calls
+// ReloadBootstrapConfig with the result. This lets an operator rotate xDS
+// credentials or repoint a process at a new control plane by updating the
+// bootstrap file on disk, without restarting the process.
+//
+// The returned cancel function stops the watch. Errors encountered while
+// re-reading or applying the file after the initial call are logged and do
+// not stop the watch, since a transiently invalid file (e.g. being rewritten
+// non-atomically) should not wedge the process into never picking up the
+// eventually-valid version.
+func (p *Pool) WatchBootstrapFile(path string) (cancel func(), err error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("xds: failed to create bootstrap file watcher: %v", err)
+	}
+	if err := watcher.Add(path); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("xds: failed to watch bootstrap file %q: %v", path, err)
+	}
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	reload := func() {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			logger.Warningf("xds: failed to read reloaded bootstrap file %q: %v", path, err)
+			return
+		}
+		cfg, err := bootstrap.NewConfigFromContents(contents)
+		if err != nil {
+			logger.Warningf("xds: failed to parse reloaded bootstrap file %q: %v", path, err)
+			return
+		}
+		if err := p.ReloadBootstrapConfig(cfg); err != nil {
+			logger.Warningf("xds: failed to apply reloaded bootstrap file %q: %v", path, err)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+					reload()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Warningf("xds: bootstrap file watcher error: %v", err)
+			case <-sighup:
+				reload()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return grpcsync.OnceFunc(func() {
+		close(done)
+		signal.Stop(sighup)
+		watcher.Close()
+	}), nil
+}
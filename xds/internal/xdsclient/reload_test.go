@@ -0,0 +1,104 @@
+/*
+ *
+ * Copyright 2024 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package xdsclient
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/internal/xds/bootstrap"
+	"google.golang.org/grpc/xds/internal/xdsclient/xdsresource"
+)
+
+// fakeAdsTransport is a no-op adsTransport that records which of its methods
+// were called, for asserting that clientImpl's reload logic picks the
+// correct one (plain restart vs. the overlap-preserving restartGraceful)
+// without needing a real network connection.
+type fakeAdsTransport struct {
+	restartCalls         []int
+	restartGracefulCalls []int
+	closed               bool
+}
+
+func (f *fakeAdsTransport) restart(serverIdx int) error {
+	f.restartCalls = append(f.restartCalls, serverIdx)
+	return nil
+}
+
+func (f *fakeAdsTransport) restartGraceful(serverIdx int) error {
+	f.restartGracefulCalls = append(f.restartGracefulCalls, serverIdx)
+	return nil
+}
+
+func (f *fakeAdsTransport) close() { f.closed = true }
+
+// TestClientImpl_ApplyAuthorityServers_PreservesWatches verifies that a
+// bootstrap reload which changes an authority's server list reconnects that
+// authority's transport gracefully (never tearing down its old stream
+// before the new one is ready), and does so without touching the
+// authority's existing watch registrations, so that in-flight watchers
+// never need to re-subscribe.
+func TestClientImpl_ApplyAuthorityServers_PreservesWatches(t *testing.T) {
+	transport := &fakeAdsTransport{}
+	watcher := &testListenerWatcher{updateCh: make(chan *xdsresource.ListenerUpdate, 1)}
+	a := &authorityState{
+		name:     "",
+		fallback: newAuthorityFallbackState(threeServers(t)),
+		watches: map[xdsresource.Type]map[string][]xdsresource.ResourceWatcher{
+			xdsresource.ListenerResourceType: {"test-listener": {watcher}},
+		},
+		transport: transport,
+	}
+	c := &clientImpl{authorities: map[string]*authorityState{"": a}}
+
+	newServers := []*bootstrap.ServerConfig{{}, {}}
+	c.applyAuthorityServersLocked("", newServers)
+
+	if len(transport.restartGracefulCalls) != 1 || transport.restartGracefulCalls[0] != 0 {
+		t.Fatalf("restartGracefulCalls = %v, want [0]", transport.restartGracefulCalls)
+	}
+	if len(transport.restartCalls) != 0 {
+		t.Fatalf("restartCalls = %v, want none: a server list change must reconnect gracefully, not with a hard cutover", transport.restartCalls)
+	}
+
+	got := c.authorities[""].watches[xdsresource.ListenerResourceType]["test-listener"]
+	if len(got) != 1 || got[0] != watcher {
+		t.Fatalf("watches after reload = %v, want the original watcher untouched", got)
+	}
+}
+
+// TestClientImpl_ApplyAuthorityServers_NoOpWhenServersUnchanged verifies
+// that a bootstrap reload which doesn't actually change an authority's
+// server list (e.g. only the Node changed) never reconnects its transport.
+func TestClientImpl_ApplyAuthorityServers_NoOpWhenServersUnchanged(t *testing.T) {
+	servers := threeServers(t)
+	transport := &fakeAdsTransport{}
+	a := &authorityState{
+		name:      "",
+		fallback:  newAuthorityFallbackState(servers),
+		watches:   map[xdsresource.Type]map[string][]xdsresource.ResourceWatcher{},
+		transport: transport,
+	}
+	c := &clientImpl{authorities: map[string]*authorityState{"": a}}
+
+	c.applyAuthorityServersLocked("", servers)
+
+	if len(transport.restartGracefulCalls) != 0 || len(transport.restartCalls) != 0 {
+		t.Fatalf("restart calls = %v / %v, want none when the server list is unchanged", transport.restartCalls, transport.restartGracefulCalls)
+	}
+}
@@ -0,0 +1,425 @@
+/*
+ *
+ * Copyright 2024 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package xdsclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	v3discoverypb "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/internal/xds/bootstrap"
+	"google.golang.org/grpc/xds/internal/xdsclient/xdsresource"
+)
+
+// grpcAdsTransport is the production adsTransport: it maintains a single ADS
+// stream, per authority, to that authority's currently active management
+// server, re-subscribing to every watched resource whenever the stream is
+// (re)established (restart/restartGraceful are the only places a stream is
+// opened, and both always replay watches). On stream failure it defers to
+// the authority's authorityFallbackState to decide whether to retry the same
+// server or fall back to the next one; once a fallback stream is healthy, it
+// probes the primary in the background and switches back once the primary
+// itself acks.
+type grpcAdsTransport struct {
+	client    *clientImpl
+	authority *authorityState
+
+	mu        sync.Mutex
+	cancelRun context.CancelFunc
+	gen       int
+	probing   bool
+}
+
+func newAdsTransport(c *clientImpl, a *authorityState) adsTransport {
+	return &grpcAdsTransport{client: c, authority: a}
+}
+
+// restart implements adsTransport: it cancels whatever stream is currently
+// running for this authority and starts a new one against serverIdx. Used
+// for the initial connect and for fallback, where the old stream (if any) is
+// already known to be unusable, so there is nothing to gain by keeping it
+// around while the new one comes up.
+func (t *grpcAdsTransport) restart(serverIdx int) error {
+	t.mu.Lock()
+	oldCancel := t.cancelRun
+	t.mu.Unlock()
+
+	if _, err := t.startRun(serverIdx, nil); err != nil {
+		return err
+	}
+	if oldCancel != nil {
+		oldCancel()
+	}
+	return nil
+}
+
+// restartGraceful is like restart, except the stream currently running (if
+// any) is kept alive, and continues serving watchers with its last-received
+// data, until the new stream against serverIdx has ACKed a response for
+// every resource type it subscribed to; only then is the old stream torn
+// down. This is what lets a
+// server URI/credential change (see applyAuthorityServersLocked) take effect
+// without a gap in which no stream is up. If the new stream never acks (it
+// keeps failing, or ctx is cancelled by a subsequent restart), the old
+// stream is simply left running.
+func (t *grpcAdsTransport) restartGraceful(serverIdx int) error {
+	t.mu.Lock()
+	oldCancel := t.cancelRun
+	t.mu.Unlock()
+
+	acked := make(chan struct{})
+	ctx, err := t.startRun(serverIdx, func() { close(acked) })
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		select {
+		case <-acked:
+			if oldCancel != nil {
+				oldCancel()
+			}
+		case <-ctx.Done():
+			// Superseded by a later restart/restartGraceful before ever
+			// acking; that call is responsible for the old stream, if it's
+			// still the one running.
+		}
+	}()
+	return nil
+}
+
+// startRun validates serverIdx, installs a new run as the authority's
+// current one (bumping gen so any earlier run still executing recognizes
+// it's been superseded), and starts it in the background. onAck, if non-nil,
+// is called at most once, the first time one of the new run's streams has
+// ACKed a response for every resource type it subscribed to. It returns the new run's context, so callers can tell when it
+// has itself been superseded.
+func (t *grpcAdsTransport) startRun(serverIdx int, onAck func()) (context.Context, error) {
+	if serverIdx < 0 || serverIdx >= len(t.authority.fallback.servers) {
+		return nil, fmt.Errorf("xds: server index %d out of range for authority %q", serverIdx, t.authority.name)
+	}
+
+	t.mu.Lock()
+	t.gen++
+	gen := t.gen
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancelRun = cancel
+	t.mu.Unlock()
+
+	go t.run(ctx, gen, t.authority.fallback.servers[serverIdx], onAck)
+	return ctx, nil
+}
+
+func (t *grpcAdsTransport) close() {
+	t.mu.Lock()
+	if t.cancelRun != nil {
+		t.cancelRun()
+	}
+	t.mu.Unlock()
+}
+
+// run keeps a stream to server alive, retrying with the client's configured
+// backoff, until ctx is cancelled (a newer restart superseded this run) or
+// the authority's fallback state decides to switch servers, in which case it
+// hands off to a freshly started run for the new server and returns. onAck,
+// if non-nil, is passed through to runStream for each attempt, and is called
+// at most once across all of them.
+func (t *grpcAdsTransport) run(ctx context.Context, gen int, server *bootstrap.ServerConfig, onAck func()) {
+	if onAck != nil {
+		var once sync.Once
+		ack := onAck
+		onAck = func() { once.Do(ack) }
+	}
+	retries := 0
+	for ctx.Err() == nil {
+		err := t.runStream(ctx, server, onAck)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			t.client.logger.Warningf("xds: ADS stream to %s failed: %v", server.ServerURI(), err)
+		}
+		recordServerFailure(t.client.metricsRecorder, t.client.target)
+		recordConnected(t.client.metricsRecorder, server.ServerURI(), false)
+
+		newIdx, switched := t.authority.fallback.onStreamFailure()
+		if switched {
+			t.mu.Lock()
+			current := gen == t.gen
+			t.mu.Unlock()
+			if current {
+				t.restart(newIdx)
+			}
+			return
+		}
+
+		retries++
+		select {
+		case <-time.After(t.client.streamBackoff(retries)):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runStream opens a single ADS stream to server, replays every currently
+// watched resource on it, and processes responses until the stream breaks or
+// ctx is cancelled. Every response is decoded and dispatched to the matching
+// watchers via dispatchResponse, and ACKed or NACKed accordingly. Once the
+// stream has ACKed a response for every resource type it subscribed to, it
+// calls onAck, if non-nil. After every response, if this authority isn't
+// already on its primary, it kicks off a background probe of the primary so
+// the authority can switch back without disrupting this stream in the
+// meantime.
+func (t *grpcAdsTransport) runStream(ctx context.Context, server *bootstrap.ServerConfig, onAck func()) error {
+	cc, err := grpc.DialContext(ctx, server.ServerURI(), grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	if err != nil {
+		return fmt.Errorf("dial %s: %w", server.ServerURI(), err)
+	}
+	defer cc.Close()
+
+	stream, err := v3discoverypb.NewAggregatedDiscoveryServiceClient(cc).StreamAggregatedResources(ctx)
+	if err != nil {
+		return fmt.Errorf("open ADS stream to %s: %w", server.ServerURI(), err)
+	}
+	if err := t.sendWatchedResources(stream); err != nil {
+		return err
+	}
+	t.client.mu.Lock()
+	pending := make(map[string]bool, len(t.authority.watches))
+	for rType, byName := range t.authority.watches {
+		if len(byName) > 0 {
+			pending[string(rType)] = true
+		}
+	}
+	t.client.mu.Unlock()
+
+	start := time.Now()
+	gotFirstResponse := false
+	ackedVersion := ""
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !gotFirstResponse {
+			recordTimeToFirstResponse(t.client.metricsRecorder, t.client.target, server.ServerURI(), time.Since(start))
+			recordConnected(t.client.metricsRecorder, server.ServerURI(), true)
+			gotFirstResponse = true
+		}
+
+		var errDetail *status.Status
+		if err := t.dispatchResponse(resp); err != nil {
+			t.client.logger.Warningf("xds: NACKing %s response from %s: %v", resp.GetTypeUrl(), server.ServerURI(), err)
+			errDetail = &status.Status{Message: err.Error()}
+		} else {
+			ackedVersion = resp.GetVersionInfo()
+			delete(pending, resp.GetTypeUrl())
+		}
+		recordResourceUpdate(t.client.metricsRecorder, t.client.target, server.ServerURI(), errDetail == nil)
+
+		if err := stream.Send(&v3discoverypb.DiscoveryRequest{
+			Node:          t.client.currentNode(),
+			VersionInfo:   ackedVersion,
+			TypeUrl:       resp.GetTypeUrl(),
+			ResponseNonce: resp.GetNonce(),
+			ErrorDetail:   errDetail,
+		}); err != nil {
+			return err
+		}
+		if onAck != nil && errDetail == nil && len(pending) == 0 {
+			onAck()
+			onAck = nil
+		}
+
+		if t.authority.fallback.onStreamSuccess() {
+			t.probePrimary()
+		}
+	}
+}
+
+// dispatchResponse decodes every resource in resp according to its declared
+// type and invokes the matching registered watchers' OnUpdate, returning a
+// non-nil error (and invoking no watcher) if any resource in the response
+// fails to parse, or if the response's type isn't one this client knows how
+// to decode yet; per the ADS protocol a DiscoveryResponse is accepted or
+// rejected as a whole, so one bad resource NACKs everything in it rather
+// than just the subset that failed.
+func (t *grpcAdsTransport) dispatchResponse(resp *v3discoverypb.DiscoveryResponse) error {
+	switch xdsresource.Type(resp.GetTypeUrl()) {
+	case xdsresource.ListenerResourceType:
+		return t.dispatchListenerResponse(resp)
+	case xdsresource.RouteConfigResourceType:
+		return t.dispatchRouteConfigResponse(resp)
+	default:
+		return fmt.Errorf("unsupported resource type %q", resp.GetTypeUrl())
+	}
+}
+
+func (t *grpcAdsTransport) dispatchListenerResponse(resp *v3discoverypb.DiscoveryResponse) error {
+	updates := make(map[string]*xdsresource.ListenerUpdate, len(resp.GetResources()))
+	for _, r := range resp.GetResources() {
+		name, u, err := xdsresource.UnmarshalListener(r)
+		if err != nil {
+			return err
+		}
+		updates[name] = u
+	}
+
+	t.client.mu.Lock()
+	watchersByName := make(map[string][]xdsresource.ResourceWatcher, len(updates))
+	for name := range updates {
+		watchersByName[name] = append([]xdsresource.ResourceWatcher(nil), t.authority.watches[xdsresource.ListenerResourceType][name]...)
+	}
+	t.client.mu.Unlock()
+
+	for name, u := range updates {
+		for _, w := range watchersByName[name] {
+			lw, ok := w.(xdsresource.ListenerWatcher)
+			if !ok {
+				continue
+			}
+			lw.OnUpdate(u)
+		}
+	}
+	return nil
+}
+
+func (t *grpcAdsTransport) dispatchRouteConfigResponse(resp *v3discoverypb.DiscoveryResponse) error {
+	updates := make(map[string]*xdsresource.RouteConfigUpdate, len(resp.GetResources()))
+	for _, r := range resp.GetResources() {
+		name, u, err := xdsresource.UnmarshalRouteConfiguration(r)
+		if err != nil {
+			return err
+		}
+		updates[name] = u
+	}
+
+	t.client.mu.Lock()
+	watchersByName := make(map[string][]xdsresource.ResourceWatcher, len(updates))
+	for name := range updates {
+		watchersByName[name] = append([]xdsresource.ResourceWatcher(nil), t.authority.watches[xdsresource.RouteConfigResourceType][name]...)
+	}
+	t.client.mu.Unlock()
+
+	for name, u := range updates {
+		for _, w := range watchersByName[name] {
+			rw, ok := w.(xdsresource.RouteConfigWatcher)
+			if !ok {
+				continue
+			}
+			rw.OnUpdate(u)
+		}
+	}
+	return nil
+}
+
+// probePrimary attempts, at most once concurrently, to dial the authority's
+// primary (server index 0) in the background and confirm it is healthy by
+// waiting for a single ack; if that succeeds it tells the fallback state the
+// primary has recovered and restarts the authority's stream against it.
+// Cached resources from the fallback server remain in use (the current
+// runStream keeps running) until that restart actually replaces it.
+func (t *grpcAdsTransport) probePrimary() {
+	t.mu.Lock()
+	if t.probing {
+		t.mu.Unlock()
+		return
+	}
+	t.probing = true
+	t.mu.Unlock()
+
+	go func() {
+		defer func() {
+			t.mu.Lock()
+			t.probing = false
+			t.mu.Unlock()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := t.ackOnce(ctx, t.authority.fallback.servers[0]); err != nil {
+			return
+		}
+		t.authority.fallback.onPrimaryRecovered()
+		t.restartGraceful(0)
+	}()
+}
+
+// ackOnce dials server, sends a single request per watched resource type,
+// and returns once the first response has been received, or ctx expires; the
+// response is not ACKed. It is used only to confirm the primary is healthy again; the
+// connection it opens is not reused (restart opens the real, long-lived
+// stream once this confirms success).
+func (t *grpcAdsTransport) ackOnce(ctx context.Context, server *bootstrap.ServerConfig) error {
+	cc, err := grpc.DialContext(ctx, server.ServerURI(), grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	if err != nil {
+		return err
+	}
+	defer cc.Close()
+
+	stream, err := v3discoverypb.NewAggregatedDiscoveryServiceClient(cc).StreamAggregatedResources(ctx)
+	if err != nil {
+		return err
+	}
+	if err := t.sendWatchedResources(stream); err != nil {
+		return err
+	}
+	_, err = stream.Recv()
+	return err
+}
+
+// sendWatchedResources sends the initial DiscoveryRequest for every resource
+// type with at least one active watch, one request per type, each carrying
+// the client's current Node (required by real ADS servers on a stream's
+// first request, and harmless to repeat on every one).
+func (t *grpcAdsTransport) sendWatchedResources(stream v3discoverypb.AggregatedDiscoveryService_StreamAggregatedResourcesClient) error {
+	t.client.mu.Lock()
+	reqs := make([]*v3discoverypb.DiscoveryRequest, 0, len(t.authority.watches))
+	for rType, byName := range t.authority.watches {
+		names := make([]string, 0, len(byName))
+		for name := range byName {
+			names = append(names, name)
+		}
+		if len(names) == 0 {
+			continue
+		}
+		reqs = append(reqs, &v3discoverypb.DiscoveryRequest{TypeUrl: string(rType), ResourceNames: names})
+	}
+	t.client.mu.Unlock()
+
+	node := t.client.currentNode()
+	for _, req := range reqs {
+		req.Node = node
+		if err := stream.Send(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+/*
+ *
+ * Copyright 2024 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package xdsclient implements a full fledged gRPC client for the xDS API
+// used by the xds resolver and balancer implementations.
+package xdsclient
+
+import (
+	"time"
+
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/internal/xds/bootstrap"
+	"google.golang.org/grpc/xds/internal/xdsclient/xdsresource"
+)
+
+const defaultWatchExpiryTimeout = 15 * time.Second
+
+var defaultStreamBackoffFunc = func(retries int) time.Duration {
+	return defaultStreamBackoffAfterFailureDuration
+}
+
+var logger = grpclog.Component("xds")
+
+// Hooks for testing: xdsClientImplCreateHook/xdsClientImplCloseHook are
+// invoked whenever Pool creates/fully-closes a named client, so that tests
+// can observe client lifecycle without reaching into Pool internals.
+var (
+	xdsClientImplCreateHook = func(name string) {}
+	xdsClientImplCloseHook  = func(name string) {}
+)
+
+// XDSClient is the interface implemented by the xDS client returned from a
+// Pool: it lets resolvers, balancers, CSDS and server-side listener wrappers
+// watch xDS resources without depending on the concrete clientImpl type.
+type XDSClient interface {
+	xdsresource.XDSClient
+
+	// BootstrapConfig returns the bootstrap configuration in use by this
+	// client at the time of the call.
+	BootstrapConfig() *bootstrap.Config
+
+	// close shuts down the client: open streams are terminated and no
+	// further callbacks are invoked. It is unexported because only Pool,
+	// via clientRefCounted, is expected to call it, once the last reference
+	// has been released.
+	close()
+}
+
+// clientRefCounted wraps a clientImpl with a reference count, so that Pool
+// can hand the same client out to multiple callers (by name) and only
+// actually close it once every caller has released their reference,
+// including a server-side caller obtained via Pool.NewServerClient.
+type clientRefCounted struct {
+	*clientImpl
+
+	refCount int32
+}
+
+func (c *clientRefCounted) incrRef() int32 {
+	c.refCount++
+	return c.refCount
+}
+
+func (c *clientRefCounted) decrRef() int32 {
+	c.refCount--
+	return c.refCount
+}
+
+var _ XDSClient = (*clientRefCounted)(nil)
+var _ XDSClient = (*clientImpl)(nil)
@@ -0,0 +1,65 @@
+/*
+ *
+ * Copyright 2024 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package xdsresource
+
+import (
+	"fmt"
+
+	v3routepb "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// RouteConfigUpdate is the content of an ACKed RouteConfiguration resource.
+// Route matching (mapping an RPC to a VirtualHost/Route by authority and
+// path) isn't implemented by this package yet; today the only thing gRPC's
+// server-side xDS support needs RDS for is confirming that a filter chain's
+// route_config_name actually resolved to something, so that
+// serverxds.ListenerWrapper can hold off serving on that filter chain until
+// it has. Name is kept so a future VirtualHost-matching implementation can
+// build on the watch plumbing already in place rather than changing it.
+type RouteConfigUpdate struct {
+	Name string
+}
+
+// RouteConfigWatcher is the callback interface for watches registered
+// through WatchRouteConfig.
+type RouteConfigWatcher interface {
+	ResourceWatcher
+	OnUpdate(*RouteConfigUpdate)
+}
+
+// WatchRouteConfig starts a watch for the RouteConfiguration resource named
+// name using client, and returns a function to cancel the watch. See
+// WatchListener for why this thin wrapper exists.
+func WatchRouteConfig(client XDSClient, name string, w RouteConfigWatcher) (cancel func()) {
+	return client.WatchResource(RouteConfigResourceType, name, w)
+}
+
+// UnmarshalRouteConfiguration parses the wire-encoded
+// envoy.config.route.v3.RouteConfiguration carried in a DiscoveryResponse's
+// Resources entry, returning the resource's name (for matching against a
+// watch) and its contents as a RouteConfigUpdate.
+func UnmarshalRouteConfiguration(resource *anypb.Any) (name string, update *RouteConfigUpdate, err error) {
+	rc := &v3routepb.RouteConfiguration{}
+	if err := proto.Unmarshal(resource.GetValue(), rc); err != nil {
+		return "", nil, fmt.Errorf("xdsresource: failed to unmarshal RouteConfiguration: %v", err)
+	}
+	return rc.GetName(), &RouteConfigUpdate{Name: rc.GetName()}, nil
+}
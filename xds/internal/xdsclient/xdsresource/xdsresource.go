@@ -0,0 +1,65 @@
+/*
+ *
+ * Copyright 2024 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package xdsresource defines the xDS resource types watched through an
+// xdsclient.XDSClient, independently of the client implementation, so that
+// watcher helpers (e.g. WatchListener) can be shared between the client-side
+// and server-side (serverxds) consumers without either importing the other.
+package xdsresource
+
+// Type identifies the kind of an xDS resource by its type URL, e.g. LDS,
+// RDS, CDS or EDS.
+type Type string
+
+// The resource types understood by this client.
+const (
+	ListenerResourceType    Type = "type.googleapis.com/envoy.config.listener.v3.Listener"
+	RouteConfigResourceType Type = "type.googleapis.com/envoy.config.route.v3.RouteConfiguration"
+	ClusterResourceType     Type = "type.googleapis.com/envoy.config.cluster.v3.Cluster"
+	EndpointsResourceType   Type = "type.googleapis.com/envoy.config.endpoint.v3.ClusterLoadAssignment"
+)
+
+// ResourceWatcher is the common interface implemented by watchers of any
+// xDS resource type: they are notified of transport/processing errors and of
+// the resource being confirmed absent, in addition to the type-specific
+// OnUpdate method (e.g. ListenerWatcher.OnUpdate) that isn't part of this
+// interface since its signature varies by resource type.
+type ResourceWatcher interface {
+	// OnError is called when an error occurs while watching the resource,
+	// for example a NACKed update. The previous good update, if any, is
+	// still considered valid.
+	OnError(err error)
+
+	// OnResourceDoesNotExist is called when the management server has
+	// confirmed that the resource does not exist.
+	OnResourceDoesNotExist()
+}
+
+// XDSClient is the subset of xdsclient.XDSClient's method set that this
+// package's watch helpers need. It is declared here, rather than depended on
+// from the xdsclient package directly, to avoid an import cycle:
+// xdsclient imports this package for the resource types used in WatchResource's
+// signature, so this package cannot import xdsclient back. xdsclient.XDSClient
+// and xdsclient.clientImpl both satisfy this interface structurally.
+type XDSClient interface {
+	// WatchResource starts a watch for the xDS resource named resourceName,
+	// of type rType. The watcher's callbacks are invoked as updates, errors,
+	// or resource-does-not-exist notifications are processed. The returned
+	// function cancels the watch; it is safe to call more than once.
+	WatchResource(rType Type, resourceName string, watcher ResourceWatcher) (cancel func())
+}